@@ -12,15 +12,13 @@ import (
 	"fmt"
 	"io"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/metric"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict"
-	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict/collection"
-	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict/perm"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
-	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsfetch"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 	"github.com/ostcar/topic"
 )
@@ -54,6 +52,11 @@ type Datastore interface {
 		f func(ctx context.Context, key dskey.Key, changed map[dskey.Key][]byte) ([]byte, error),
 	)
 	HistoryInformation(ctx context.Context, fqid string, w io.Writer) error
+	HistoryInformationMany(ctx context.Context, fqids []string, w io.Writer) error
+	HistoryInformationFiltered(ctx context.Context, fqids []string, filter datastore.HistoryFilter, w io.Writer) error
+	Position() int
+	WaitForPosition(ctx context.Context, position int) error
+	PositionAt(ctx context.Context, t time.Time) (int, error)
 }
 
 // KeysBuilder holds the keys that are requested by a user.
@@ -65,25 +68,91 @@ type KeysBuilder interface {
 // RestrictMiddleware is a function that can restrict data.
 type RestrictMiddleware func(getter datastore.Getter, uid int) datastore.Getter
 
+// PermissionClasser groups users, that get the same restricted data.
+//
+// It is used to share the computed data between connections of different
+// users.
+type PermissionClasser interface {
+	// PermissionClass returns a string, that is the same for all users with
+	// the same permissions.
+	PermissionClass(ctx context.Context, getter datastore.Getter, uid int) (string, error)
+
+	// TrackUserSpecific returns a context for the restricter. The returned
+	// function reports, if the restricted data depends on the user id itself
+	// and not only on the permission class.
+	TrackUserSpecific(ctx context.Context) (context.Context, func() bool)
+}
+
 // Autoupdate holds the state of the autoupdate service. It has to be initialized
 // with autoupdate.New().
 type Autoupdate struct {
 	datastore  Datastore
 	topic      *topic.Topic[dskey.Key]
 	restricter RestrictMiddleware
+	classer    PermissionClasser
+	resume     *resumeStore
+	published  *publishLog
+	shared     *sharedStore
+	hotkeys    *hotkeyIndex
+	maxLag     time.Duration
+	coalescer  *coalescer
+
+	metricTooSlow uint64
+}
+
+// Option is an optional argument for New.
+type Option func(*Autoupdate)
+
+// WithMaxLag sets the time, a connection can fall behind the topic, before it
+// is closed with the error too_slow. Zero, the default, disables the limit.
+func WithMaxLag(d time.Duration) Option {
+	return func(a *Autoupdate) {
+		a.maxLag = d
+	}
+}
+
+// WithCoalesceWindow collects all changes in the given time and sends them to
+// the clients together. Zero disables it.
+func WithCoalesceWindow(d time.Duration) Option {
+	return func(a *Autoupdate) {
+		a.coalescer.window = d
+	}
+}
+
+// WithPermissionClasser shares the computed data between users with the same
+// permission class. Without it, the data is only shared between connections of
+// the same user.
+func WithPermissionClasser(c PermissionClasser) Option {
+	return func(a *Autoupdate) {
+		a.classer = c
+	}
 }
 
 // New creates a new autoupdate service.
 //
 // You should call `go a.PruneOldData()` and `go a.ResetCache()` after creating
 // the service.
-func New(ds Datastore, restricter RestrictMiddleware) (*Autoupdate, func(context.Context, func(error))) {
+func New(ds Datastore, restricter RestrictMiddleware, options ...Option) (*Autoupdate, func(context.Context, func(error))) {
 	a := &Autoupdate{
 		datastore:  ds,
 		topic:      topic.New[dskey.Key](),
 		restricter: restricter,
+		resume:     newResumeStore(),
+		published:  new(publishLog),
+		shared:     newSharedStore(),
+	}
+
+	a.hotkeys = newHotkeyIndex(a.topic, newMeetingResolver(ds))
+	a.coalescer = &coalescer{publish: a.publish}
+
+	for _, o := range options {
+		o(a)
 	}
 
+	// Start the topic with the id 1. The id is sent to the clients, and 0
+	// means, that a client did not receive any data.
+	a.topic.Publish()
+
 	// Update the topic when an data update is received.
 	a.datastore.RegisterChangeListener(func(data map[dskey.Key][]byte) error {
 		keys := make([]dskey.Key, 0, len(data))
@@ -91,10 +160,12 @@ func New(ds Datastore, restricter RestrictMiddleware) (*Autoupdate, func(context
 			keys = append(keys, k)
 		}
 
-		a.topic.Publish(keys...)
+		a.coalescer.add(keys)
 		return nil
 	})
 
+	metric.Register(a.metric)
+
 	background := func(ctx context.Context, errorHandler func(error)) {
 		go a.pruneOldData(ctx)
 		go a.resetCache(ctx)
@@ -111,15 +182,50 @@ type DataProvider func() (func(ctx context.Context) (map[dskey.Key][]byte, error
 //
 // There is no need to "close" the returned DataProvider.
 func (a *Autoupdate) Connect(userID int, kb KeysBuilder) DataProvider {
-	c := &connection{
-		autoupdate: a,
-		uid:        userID,
-		kb:         kb,
-	}
+	c := newConnection(a, userID)
+	c.subscribe("", kb)
 
 	return c.Next
 }
 
+// Multiplex creates a connection, that can have many subscriptions. The
+// subscriptions can be added and removed while the connection is running.
+//
+// There is no need to "close" the returned Multiplexer.
+func (a *Autoupdate) Multiplex(userID int) *Multiplexer {
+	return &Multiplexer{
+		conn: newConnection(a, userID),
+	}
+}
+
+// Position returns the datastore position of the data, that is used by the
+// service at the moment.
+func (a *Autoupdate) Position() int {
+	return a.datastore.Position()
+}
+
+// PositionAt returns the last datastore position, that was created before or
+// at the given time. Returns 0, if there is no such position.
+func (a *Autoupdate) PositionAt(ctx context.Context, t time.Time) (int, error) {
+	position, err := a.datastore.PositionAt(ctx, t)
+	if err != nil {
+		return 0, fmt.Errorf("getting position by time: %w", err)
+	}
+	return position, nil
+}
+
+// WaitForPosition blocks until the service has received the update with the
+// given datastore position or the context is done.
+//
+// Data that is read afterwards contains at least all changes until this
+// position.
+func (a *Autoupdate) WaitForPosition(ctx context.Context, position int) error {
+	if err := a.datastore.WaitForPosition(ctx, position); err != nil {
+		return fmt.Errorf("waiting for position %d: %w", position, err)
+	}
+	return nil
+}
+
 // SingleData returns the data for the given keysbuilder without autoupdates.
 //
 // The attribute position can be used to get data from the history.
@@ -149,8 +255,8 @@ func (a *Autoupdate) SingleData(ctx context.Context, userID int, kb KeysBuilder,
 	return data, nil
 }
 
-// pruneOldData removes old data from the topic. Blocks until the service is
-// closed.
+// pruneOldData removes old data from the topic and old states of closed
+// connections. Blocks until the service is closed.
 func (a *Autoupdate) pruneOldData(ctx context.Context) {
 	tick := time.NewTicker(time.Minute)
 	defer tick.Stop()
@@ -161,10 +267,30 @@ func (a *Autoupdate) pruneOldData(ctx context.Context) {
 			return
 		case <-tick.C:
 			a.topic.Prune(time.Now().Add(-pruneTime))
+			a.resume.prune(time.Now().Add(-pruneTime))
+			a.published.prune(time.Now().Add(-pruneTime))
+			a.shared.prune(time.Now().Add(-time.Minute))
+			a.hotkeys.prune()
 		}
 	}
 }
 
+// publish adds the keys to the topic and wakes the connections.
+func (a *Autoupdate) publish(keys []dskey.Key) {
+	tid := a.hotkeys.publish(keys)
+	a.published.add(tid, time.Now())
+}
+
+// tooSlow is called, when a connection gets closed, because it fell behind.
+func (a *Autoupdate) tooSlow(lag time.Duration) error {
+	atomic.AddUint64(&a.metricTooSlow, 1)
+	return tooSlowError{lag: lag}
+}
+
+func (a *Autoupdate) metric(values metric.Container) {
+	values.Add("autoupdate_too_slow", int(atomic.LoadUint64(&a.metricTooSlow)))
+}
+
 // resetCache runs in the background and cleans the cache from time to time.
 // Blocks until the service is closed.
 func (a *Autoupdate) resetCache(ctx context.Context) {
@@ -183,55 +309,74 @@ func (a *Autoupdate) resetCache(ctx context.Context) {
 
 var reValidKeys = regexp.MustCompile(`^([a-z]+|[a-z][a-z_]*[a-z])/[1-9][0-9]*`)
 
-// HistoryInformation writes the history information for an fqid.
-func (a *Autoupdate) HistoryInformation(ctx context.Context, uid int, fqid string, w io.Writer) error {
-	if !reValidKeys.MatchString(fqid) {
-		// TODO Client Error
-		return invalidInputError{fmt.Sprintf("fqid %s is invalid", fqid)}
+// HistoryInformation writes the history information for an fqid. Only the
+// entries, that match the filter, are written.
+func (a *Autoupdate) HistoryInformation(ctx context.Context, uid int, fqid string, filter HistoryFilter, w io.Writer) error {
+	if err := newHistoryPermission(a.datastore, uid).check(ctx, fqid); err != nil {
+		return err
 	}
 
-	coll, rawID, _ := strings.Cut(fqid, "/")
-	id, _ := strconv.Atoi(rawID)
+	read := func(w io.Writer) error {
+		return a.datastore.HistoryInformation(ctx, fqid, w)
+	}
+	return a.writeHistory(ctx, []string{fqid}, read, filter, w)
+}
 
-	ds := dsfetch.New(a.datastore)
+// HistoryInformationBatch writes the history information for many fqids as an
+// object from the fqid to its entries. Only the entries, that match the
+// filter, are written.
+//
+// In difference to HistoryInformation, fqids that the user is not allowed to
+// see or that do not exist are skipped.
+func (a *Autoupdate) HistoryInformationBatch(ctx context.Context, uid int, fqids []string, filter HistoryFilter, w io.Writer) error {
+	permission := newHistoryPermission(a.datastore, uid)
 
-	meetingID, hasMeeting, err := collection.Collection(coll).MeetingID(ctx, ds, id)
-	if err != nil {
-		var errNotExist dsfetch.DoesNotExistError
-		if errors.As(err, &errNotExist) {
-			// TODO Client Error
-			return notExistError{dskey.Key(errNotExist)}
+	allowed := make([]string, 0, len(fqids))
+	for _, fqid := range fqids {
+		if err := permission.check(ctx, fqid); err != nil {
+			var errPermission permissionDeniedError
+			var errNotExist notExistError
+			if errors.As(err, &errPermission) || errors.As(err, &errNotExist) {
+				continue
+			}
+			return err
 		}
-		return fmt.Errorf("getting meeting id for collection %s id %d: %w", coll, id, err)
+		allowed = append(allowed, fqid)
 	}
 
-	if !hasMeeting {
-		hasOML, err := perm.HasOrganizationManagementLevel(ctx, ds, uid, perm.OMLCanManageOrganization)
-		if err != nil {
-			return fmt.Errorf("getting organization management level: %w", err)
+	if len(allowed) == 0 {
+		// Write the empty history information in the same form as with
+		// fqids. So with a limit, it is a page.
+		if err := datastore.FilterHistory(strings.NewReader("{}"), filter, w); err != nil {
+			return fmt.Errorf("writing empty history information: %w", err)
 		}
+		return nil
+	}
 
-		if !hasOML {
-			// TODO Client Error
-			return permissionDeniedError{fmt.Errorf("you are not allowed to use history information on %s", fqid)}
-		}
-	} else {
-		p, err := perm.New(ctx, ds, uid, meetingID)
-		if err != nil {
-			return fmt.Errorf("getting meeting permissions: %w", err)
-		}
+	read := func(w io.Writer) error {
+		return a.datastore.HistoryInformationMany(ctx, allowed, w)
+	}
+	return a.writeHistory(ctx, allowed, read, filter, w)
+}
 
-		if !p.Has(perm.MeetingCanSeeHistory) {
-			// TODO Client Error
-			return permissionDeniedError{fmt.Errorf("you are not allowed to use history information on %s", fqid)}
+// writeHistory writes the history information for the fqids without checking
+// the permissions.
+//
+// Without a filter, the history information is read with the given function.
+// Else the datastore only returns the entries, that match the filter.
+func (a *Autoupdate) writeHistory(ctx context.Context, fqids []string, read func(w io.Writer) error, filter HistoryFilter, w io.Writer) error {
+	if filter.Empty() {
+		if err := read(w); err != nil {
+			return fmt.Errorf("getting history information: %w", err)
 		}
-	}
 
-	if err := a.datastore.HistoryInformation(ctx, fqid, w); err != nil {
-		return fmt.Errorf("getting history information: %w", err)
+		fmt.Fprintln(w)
+		return nil
 	}
 
-	fmt.Fprintln(w)
+	if err := a.datastore.HistoryInformationFiltered(ctx, fqids, filter, w); err != nil {
+		return fmt.Errorf("getting filtered history information: %w", err)
+	}
 
 	return nil
 }
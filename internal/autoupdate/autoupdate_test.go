@@ -6,10 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
 )
 
@@ -37,6 +41,50 @@ func TestSingleDataEmptyValues(t *testing.T) {
 	}
 }
 
+func TestWaitForPosition(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(dsmock.YAMLData(`---
+		user/1/username: foo
+	`))
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.WaitForPosition(context.Background(), 1)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("WaitForPosition returned before the update")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	ds.Send(dsmock.YAMLData(`user/1/username: bar`))
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitForPosition: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitForPosition did not return after the update")
+	}
+
+	kb, _ := keysbuilder.FromKeys("user/1/username")
+	data, err := s.SingleData(context.Background(), 1, kb, 0)
+	if err != nil {
+		t.Fatalf("SingleData: %v", err)
+	}
+
+	if got := string(data[dskey.MustKey("user/1/username")]); got != `"bar"` {
+		t.Errorf("got %s, expected \"bar\"", got)
+	}
+}
+
 func TestHistoryInformation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -47,22 +95,59 @@ func TestHistoryInformation(t *testing.T) {
 	s, _ := autoupdate.New(ds, RestrictAllowed)
 
 	buf := new(bytes.Buffer)
-	err := s.HistoryInformation(ctx, 1, "collection/1", buf)
+	err := s.HistoryInformation(ctx, 1, "collection/1", autoupdate.HistoryFilter{}, buf)
 
 	if err != nil {
 		t.Fatalf("HistoryInformation: %v", err)
 	}
 
-	var information []interface{}
+	var information map[string][]interface{}
 	if err := json.Unmarshal(buf.Bytes(), &information); err != nil {
 		t.Fatalf("HistoryInformation returned invalid data `%v`: %v", buf.String(), err)
 	}
 
-	if len(information) == 0 {
+	if len(information["collection/1"]) == 0 {
 		t.Errorf("No History returned")
 	}
 }
 
+func TestHistoryInformationFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, _ := dsmock.NewMockDatastore(dsmock.YAMLData(`---
+		user/1/organization_management_level: superadmin
+	`))
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+
+	for _, tt := range []struct {
+		name        string
+		maxPosition int
+		expect      int
+	}{
+		{"after entry", 50, 1},
+		{"at entry", 42, 1},
+		{"before entry", 41, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			filter := autoupdate.HistoryFilter{MaxPosition: tt.maxPosition}
+			if err := s.HistoryInformation(ctx, 1, "collection/1", filter, buf); err != nil {
+				t.Fatalf("HistoryInformation: %v", err)
+			}
+
+			var information map[string][]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &information); err != nil {
+				t.Fatalf("HistoryInformation returned invalid data `%v`: %v", buf.String(), err)
+			}
+
+			if got := len(information["collection/1"]); got != tt.expect {
+				t.Errorf("got %d entries, expected %d", got, tt.expect)
+			}
+		})
+	}
+}
+
 func TestHistoryInformationWrongFQID(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -73,7 +158,7 @@ func TestHistoryInformationWrongFQID(t *testing.T) {
 	s, _ := autoupdate.New(ds, RestrictAllowed)
 
 	buf := new(bytes.Buffer)
-	err := s.HistoryInformation(ctx, 1, "collection", buf)
+	err := s.HistoryInformation(ctx, 1, "collection", autoupdate.HistoryFilter{}, buf)
 
 	var errType interface {
 		Type() string
@@ -99,22 +184,82 @@ func TestHistoryInformationSuperAdminOnMeetingCollection(t *testing.T) {
 	s, _ := autoupdate.New(ds, RestrictAllowed)
 
 	buf := new(bytes.Buffer)
-	err := s.HistoryInformation(ctx, 1, "motion/5", buf)
+	err := s.HistoryInformation(ctx, 1, "motion/5", autoupdate.HistoryFilter{}, buf)
 
 	if err != nil {
 		t.Fatalf("HistoryInformation: %v", err)
 	}
 
-	var information []interface{}
+	var information map[string][]interface{}
 	if err := json.Unmarshal(buf.Bytes(), &information); err != nil {
 		t.Fatalf("HistoryInformation returned invalid data `%v`: %v", buf.String(), err)
 	}
 
-	if len(information) == 0 {
+	if len(information["motion/5"]) == 0 {
 		t.Errorf("No History returned")
 	}
 }
 
+func TestHistoryInformationBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, _ := dsmock.NewMockDatastore(dsmock.YAMLData(`---
+		user/1/organization_management_level: can_manage_organization
+		organization/1/id: 1
+
+		motion/5/meeting_id: 1
+		meeting/1/id: 1
+	`))
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+
+	buf := new(bytes.Buffer)
+	err := s.HistoryInformationBatch(ctx, 1, []string{"organization/1", "motion/5", "motion/404"}, autoupdate.HistoryFilter{}, buf)
+	if err != nil {
+		t.Fatalf("HistoryInformationBatch: %v", err)
+	}
+
+	var information map[string][]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &information); err != nil {
+		t.Fatalf("HistoryInformationBatch returned invalid data `%v`: %v", buf.String(), err)
+	}
+
+	if len(information) != 1 || len(information["organization/1"]) == 0 {
+		t.Errorf("got %v, expected only the history of organization/1", information)
+	}
+}
+
+func TestHistoryInformationBatchNoneAllowed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, _ := dsmock.NewMockDatastore(dsmock.YAMLData(`---
+		user/1/id: 1
+		organization/1/id: 1
+	`))
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+
+	for _, tt := range []struct {
+		name   string
+		filter autoupdate.HistoryFilter
+		expect string
+	}{
+		{"without limit", autoupdate.HistoryFilter{}, `{}`},
+		{"with limit", autoupdate.HistoryFilter{Limit: 10}, `{"information":{}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := s.HistoryInformationBatch(ctx, 1, []string{"organization/1"}, tt.filter, buf); err != nil {
+				t.Fatalf("HistoryInformationBatch: %v", err)
+			}
+
+			if got := strings.TrimSpace(buf.String()); got != tt.expect {
+				t.Errorf("got %s, expected %s", got, tt.expect)
+			}
+		})
+	}
+}
+
 func TestRestrictFQIDs(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
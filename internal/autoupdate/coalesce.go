@@ -0,0 +1,70 @@
+package autoupdate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// coalescer collects the changed keys for some time and publishes them
+// together.
+//
+// When the backend writes many events in a row, for example on a meeting
+// import, each connection would create a new message for each event. With the
+// coalescer, it creates only one.
+type coalescer struct {
+	window  time.Duration
+	publish func([]dskey.Key)
+
+	// publishMu is held while the keys are published. So a flush does not
+	// start before the last publish returned and the topic ids are created
+	// in order.
+	publishMu sync.Mutex
+
+	mu        sync.Mutex
+	keys      map[dskey.Key]struct{}
+	scheduled bool
+}
+
+// add publishes the keys after the window. If the window is zero, the keys are
+// published immediately.
+func (c *coalescer) add(keys []dskey.Key) {
+	if c.window <= 0 {
+		c.publish(keys)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys == nil {
+		c.keys = make(map[dskey.Key]struct{}, len(keys))
+	}
+
+	for _, key := range keys {
+		c.keys[key] = struct{}{}
+	}
+
+	if !c.scheduled {
+		c.scheduled = true
+		time.AfterFunc(c.window, c.flush)
+	}
+}
+
+// flush publishes all collected keys.
+func (c *coalescer) flush() {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+
+	c.mu.Lock()
+	keys := make([]dskey.Key, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+	c.keys = nil
+	c.scheduled = false
+	c.mu.Unlock()
+
+	c.publish(keys)
+}
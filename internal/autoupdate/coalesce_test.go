@@ -0,0 +1,55 @@
+package autoupdate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+func TestCoalescerPublishesOneAtATime(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning, calls int
+	published := make(chan struct{}, 2)
+
+	c := &coalescer{
+		window: time.Millisecond,
+		publish: func(keys []dskey.Key) {
+			mu.Lock()
+			running++
+			calls++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			first := calls == 1
+			mu.Unlock()
+
+			if first {
+				// A slow publish, while the next keys are added.
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			published <- struct{}{}
+		},
+	}
+
+	c.add([]dskey.Key{dskey.MustKey("motion/1/title")})
+	time.Sleep(10 * time.Millisecond)
+	c.add([]dskey.Key{dskey.MustKey("motion/2/title")})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-published:
+		case <-time.After(time.Second):
+			t.Fatalf("keys were not published")
+		}
+	}
+
+	if maxRunning != 1 {
+		t.Errorf("%d publishes ran at the same time, expected 1", maxRunning)
+	}
+}
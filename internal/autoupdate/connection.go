@@ -2,21 +2,165 @@ package autoupdate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
-	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsrecorder"
+	"github.com/ostcar/topic"
 )
 
+// errSubscriptionChanged is returned by connection.receive when a subscription
+// was added or changed while waiting for new data.
+var errSubscriptionChanged = errors.New("subscription changed")
+
 // connection holds the state of a client. It has to be created by colling
-// Connect() on a autoupdate.Service instance.
+// Connect() or Multiplex() on a autoupdate.Service instance.
+//
+// A connection can have many subscriptions. Each subscription is a
+// keysbuilder with its own filter. All subscriptions of a connection wait on
+// the same topic and share the restricted data.
 type connection struct {
 	autoupdate *Autoupdate
 	uid        int
-	kb         KeysBuilder
 	tid        uint64
-	filter     filter
-	hotkeys    map[dskey.Key]struct{}
+	started    bool
+
+	// sentTid is the topic id of the data that was returned last.
+	sentTid uint64
+
+	// position is the datastore position of the data that was returned last.
+	position int
+
+	// broken is true, if the filters contain data, that was not returned,
+	// because the client went away while the data was created. In this case,
+	// the subscriptions can not be resumed.
+	broken bool
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+
+	// changed is closed, when a subscription is added or changed.
+	// Afterwards, a new channel is created.
+	changed chan struct{}
+
+	// wake gets a message, when the hotkey index wakes the connection. The
+	// changed keys are in wakeKeys.
+	wake     chan struct{}
+	wakeMu   sync.Mutex
+	wakeKeys []dskey.Key
+}
+
+func newConnection(a *Autoupdate, uid int) *connection {
+	return &connection{
+		autoupdate:    a,
+		uid:           uid,
+		subscriptions: make(map[string]*subscription),
+		changed:       make(chan struct{}),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// subscription is one keysbuilder of a connection.
+type subscription struct {
+	kb      KeysBuilder
+	filter  filter
+	hotkeys map[dskey.Key]struct{}
+
+	// keys are the keys of the keysbuilder from the last time, the data was
+	// created.
+	keys []dskey.Key
+
+	// newKB is a keysbuilder, that replaces kb the next time, the data is
+	// created.
+	newKB KeysBuilder
+
+	// request identifies the request of the subscription. If it is not empty,
+	// the state of the subscription is parked, when the connection is closed.
+	request string
+
+	// resumed is true, if the state of the subscription was restored from a
+	// closed connection, but no data was created afterwards.
+	resumed bool
+}
+
+// subscribe adds a subscription. An existing subscription with the same id is
+// replaced.
+func (c *connection) subscribe(id string, kb KeysBuilder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscriptions[id] = &subscription{kb: kb}
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// change replaces the keysbuilder of a subscription. In difference to
+// subscribe, the filter of the subscription is kept. So only the values, that
+// the client did not already receive, are returned.
+//
+// If there is no subscription with the id, change works like subscribe.
+func (c *connection) change(id string, kb KeysBuilder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub, ok := c.subscriptions[id]
+	if !ok {
+		c.subscriptions[id] = &subscription{kb: kb}
+	} else {
+		sub.newKB = kb
+	}
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// resume adds a subscription, that can be resumed on another connection.
+//
+// If there is a parked state for the request at the topic id since, it is
+// used, so only the values, that changed since then, are returned.
+func (c *connection) resume(id string, kb KeysBuilder, request string, since uint64) {
+	sub := &subscription{kb: kb, request: request}
+	if since != 0 {
+		sub.resumed = c.autoupdate.resume.take(resumeKey{uid: c.uid, request: request, tid: since}, sub)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscriptions[id] = sub
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// park saves the state of all resumable subscriptions, so they can be resumed
+// on another connection.
+func (c *connection) park() {
+	if c.broken {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, sub := range c.subscriptions {
+		if sub.request == "" || sub.filter.empty() || sub.resumed {
+			continue
+		}
+
+		c.autoupdate.resume.park(resumeKey{uid: c.uid, request: sub.request, tid: c.sentTid}, sub)
+	}
+}
+
+// unsubscribe removes a subscription.
+func (c *connection) unsubscribe(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.subscriptions, id)
 }
 
 // Next returns a function to fetch the next data.
@@ -50,74 +194,397 @@ type connection struct {
 //
 // On every other call, it blocks until there is new data. In this case, the map
 // is never empty.
+//
+// Next only returns the data for the subscription with the empty id.
 func (c *connection) Next() (func(context.Context) (map[dskey.Key][]byte, error), bool) {
 	return func(ctx context.Context) (map[dskey.Key][]byte, error) {
-		if c.filter.empty() {
-			c.tid = c.autoupdate.topic.LastID()
-			data, err := c.updatedData(ctx)
-			if err != nil {
-				return nil, fmt.Errorf("creating first time data: %w", err)
+		data, errs, err := c.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := errs[""]; err != nil {
+			return nil, err
+		}
+
+		return data[""], nil
+	}, true
+}
+
+// next returns the data for all subscriptions that have new data. The keys of
+// the returned maps are the subscription ids.
+//
+// The data for new subscriptions is returned without blocking. It can be
+// empty. If there are no new subscriptions, next blocks until there is new
+// data for at least one subscription.
+//
+// If the data for a subscription can not be created, the error is returned in
+// the second map and the subscription is removed. The other subscriptions are
+// not affected. The returned error is only set, if the connection can not be
+// used anymore.
+func (c *connection) next(ctx context.Context) (map[string]map[dskey.Key][]byte, map[string]error, error) {
+	if !c.started {
+		c.started = true
+		c.tid = c.autoupdate.topic.LastID()
+	}
+
+	for {
+		c.mu.Lock()
+		changed := c.changed
+		newSubscriptions := make(map[string]*subscription)
+		for id, sub := range c.subscriptions {
+			if sub.newKB != nil {
+				sub.kb = sub.newKB
+				sub.newKB = nil
+				newSubscriptions[id] = sub
+				continue
 			}
 
-			return data, nil
+			if sub.filter.empty() || sub.resumed {
+				newSubscriptions[id] = sub
+			}
 		}
+		c.mu.Unlock()
 
-		for {
-			// Blocks until new data or the context is done.
-			tid, changedKeys, err := c.autoupdate.topic.Receive(ctx, c.tid)
+		if len(newSubscriptions) > 0 {
+			data, errs, err := c.updatedData(ctx, newSubscriptions, true)
 			if err != nil {
-				// TODO EXTERMAL ERROR
-				return nil, fmt.Errorf("get updated keys: %w", err)
+				c.broken = true
+				return nil, nil, fmt.Errorf("creating first time data: %w", err)
 			}
-			c.tid = tid
-
-			foundKey := false
-			for _, key := range changedKeys {
-				if _, ok := c.hotkeys[key]; ok {
-					foundKey = true
-					break
-				}
+
+			c.sentTid = c.tid
+			return data, errs, nil
+		}
+
+		// The client fetches the data slower then it changes. All changes
+		// since c.tid are received at once, so the client gets them in one
+		// message. But if this takes too long, the connection is closed.
+		if lag := c.autoupdate.published.lag(c.tid, time.Now()); c.autoupdate.maxLag > 0 && lag > c.autoupdate.maxLag {
+			// The client can resume on a new connection.
+			c.park()
+			return nil, nil, c.autoupdate.tooSlow(lag)
+		}
+
+		// Blocks until new data, a new subscription or the context is done.
+		tid, changedKeys, err := c.receive(ctx, changed)
+		if err != nil {
+			if errors.Is(err, errSubscriptionChanged) {
+				continue
+			}
+
+			var errUnknownID topic.UnknownIDError
+			if errors.As(err, &errUnknownID) {
+				// The data for c.tid was already pruned.
+				c.park()
+				return nil, nil, c.autoupdate.tooSlow(0)
 			}
 
-			if foundKey {
-				data, err := c.updatedData(ctx)
-				if err != nil {
-					return nil, fmt.Errorf("creating later data: %w", err)
-				}
+			if ctx.Err() != nil {
+				// The client is gone. Maybe it comes back.
+				c.park()
+			}
 
-				if len(data) > 0 {
-					return data, nil
-				}
+			// TODO EXTERMAL ERROR
+			return nil, nil, fmt.Errorf("get updated keys: %w", err)
+		}
+		c.tid = tid
+
+		c.mu.Lock()
+		changedSubscriptions := make(map[string]*subscription)
+		for id, sub := range c.subscriptions {
+			if sub.hasHotkey(changedKeys) {
+				changedSubscriptions[id] = sub
 			}
 		}
-	}, true
+		c.mu.Unlock()
+
+		if len(changedSubscriptions) == 0 {
+			continue
+		}
+
+		data, errs, err := c.updatedData(ctx, changedSubscriptions, false)
+		if err != nil {
+			c.broken = true
+			return nil, nil, fmt.Errorf("creating later data: %w", err)
+		}
+
+		if len(data) > 0 || len(errs) > 0 {
+			c.sentTid = c.tid
+			return data, errs, nil
+		}
+	}
 }
 
-// updatedData returns all values from the datastore.getter.
-func (c *connection) updatedData(ctx context.Context) (map[dskey.Key][]byte, error) {
-	recorder := dsrecorder.New(c.autoupdate.datastore)
-	restricter := c.autoupdate.restricter(recorder, c.uid)
+// receive waits for the next keys in the topic, that are hotkeys of one of the
+// subscriptions.
+//
+// If there was new data since c.tid, it is returned without waiting. Otherwise
+// the connection is added to the hotkey index and only gets woken, when one of
+// its hotkeys changes.
+//
+// It returns errSubscriptionChanged, if the given channel is closed before
+// there is new data.
+func (c *connection) receive(ctx context.Context, changed <-chan struct{}) (uint64, []dskey.Key, error) {
+	if c.autoupdate.topic.LastID() > c.tid {
+		return c.autoupdate.topic.Receive(ctx, c.tid)
+	}
 
-	oldKeys := c.kb.Keys()
-	if err := c.kb.Update(ctx, restricter); err != nil {
-		return nil, fmt.Errorf("create keys for keysbuilder: %w", err)
+	// Remove wake ups from the last time the connection was waiting.
+	c.wakeMu.Lock()
+	c.wakeKeys = nil
+	c.wakeMu.Unlock()
+	select {
+	case <-c.wake:
+	default:
+	}
+
+	hotkeys := c.hotkeys()
+	resolved := c.autoupdate.hotkeys.meetings.resolve(ctx, hotkeys)
+	if lastID := c.autoupdate.hotkeys.register(c, hotkeys, resolved); lastID > c.tid {
+		// There was new data before the connection was added to the index.
+		c.autoupdate.hotkeys.unregister(c, hotkeys)
+		return c.autoupdate.topic.Receive(ctx, c.tid)
+	}
+
+	var reason error
+	select {
+	case <-c.wake:
+	case <-changed:
+		reason = errSubscriptionChanged
+	case <-ctx.Done():
+		reason = ctx.Err()
+	}
+
+	// The data until lastID, that did not wake the connection, does not
+	// change any hotkey.
+	lastID := c.autoupdate.hotkeys.unregister(c, hotkeys)
+
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+
+	c.wakeMu.Lock()
+	keys := c.wakeKeys
+	c.wakeMu.Unlock()
+
+	if len(keys) == 0 && reason != nil {
+		c.tid = lastID
+		return 0, nil, reason
+	}
+
+	return lastID, keys, nil
+}
+
+// wakeUp is called by the hotkey index, when some of the hotkeys of the
+// connection have changed.
+func (c *connection) wakeUp(keys []dskey.Key) {
+	c.wakeMu.Lock()
+	c.wakeKeys = append(c.wakeKeys, keys...)
+	c.wakeMu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// hotkeys returns the hotkeys of all subscriptions.
+func (c *connection) hotkeys() []dskey.Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[dskey.Key]struct{})
+	var keys []dskey.Key
+	for _, sub := range c.subscriptions {
+		for key := range sub.hotkeys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// updatedData returns the data for the given subscriptions.
+//
+// All subscriptions share one restricter. So keys, that are requested by many
+// subscriptions, are only restricted once.
+//
+// If withEmpty is true, subscriptions without data are also returned.
+//
+// Subscriptions, that return an error, are removed from the connection. Their
+// errors are returned in the second map. Only if the context is done, an error
+// is returned for the whole call.
+func (c *connection) updatedData(ctx context.Context, subscriptions map[string]*subscription, withEmpty bool) (map[string]map[dskey.Key][]byte, map[string]error, error) {
+	cache := newRestrictCache(c.autoupdate.datastore, c.autoupdate.restricter, c.autoupdate.classer, c.uid)
+	cache.loadClass(ctx)
+
+	// The data can be shared with other connections, that computed it after
+	// the last update. c.tid can be older, if the connection was not woken up.
+	cache.tid = c.autoupdate.topic.LastID()
+	cache.position = c.autoupdate.datastore.Position()
+
+	result := make(map[string]map[dskey.Key][]byte, len(subscriptions))
+	position := cache.position
+	var errs map[string]error
+	for id, sub := range subscriptions {
+		data, subPosition, err := sub.updatedData(ctx, cache, c.autoupdate.shared)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[id] = err
+			continue
+		}
+
+		sub.resumed = false
+
+		// Shared data can be older.
+		if subPosition < position {
+			position = subPosition
+		}
+
+		if len(data) == 0 && !withEmpty {
+			continue
+		}
+
+		result[id] = data
+	}
+
+	// Do not return data for subscriptions, that where removed or replaced in
+	// the meantime.
+	c.mu.Lock()
+	for id, sub := range subscriptions {
+		if c.subscriptions[id] != sub {
+			delete(result, id)
+			delete(errs, id)
+			continue
+		}
+
+		if _, ok := errs[id]; ok {
+			// The client has to subscribe again.
+			delete(c.subscriptions, id)
+		}
+	}
+	c.mu.Unlock()
+
+	c.position = position
+	return result, errs, nil
+}
+
+// updatedData returns all values for the subscription from the cache and the
+// datastore position of the values.
+//
+// If the keysbuilder has a fingerprint, the values are shared with all
+// subscriptions with the same request and the same permission class. If the
+// values depend on the user id, they are only shared with subscriptions of the
+// same user.
+func (s *subscription) updatedData(ctx context.Context, cache *restrictCache, shared *sharedStore) (map[dskey.Key][]byte, int, error) {
+	compute := func() (*sharedResult, error) {
+		return s.compute(ctx, cache)
+	}
+
+	var result *sharedResult
+	var err error
+	var withClass bool
+	if fp, ok := s.kb.(interface{ Fingerprint() string }); ok && fp.Fingerprint() != "" {
+		result, err = s.sharedData(ctx, cache, shared, fp.Fingerprint(), compute)
+		withClass = cache.class != ""
+	} else {
+		result, err = compute()
+	}
+	if err != nil {
+		return nil, 0, err
 	}
 
-	newKeys := c.kb.Keys()
-	removedKeys := notInSlice(oldKeys, newKeys)
+	removedKeys := notInSlice(s.keys, result.keys)
 	for _, key := range removedKeys {
-		c.filter.delete(key)
+		s.filter.delete(key)
+	}
+	s.keys = result.keys
+	s.hotkeys = result.hotkeys
+
+	if withClass {
+		// The data was maybe computed by another user. So the subscription has
+		// to be updated, when the permission class of the user changes.
+		s.hotkeys = make(map[dskey.Key]struct{}, len(result.hotkeys)+len(cache.classKeys))
+		for key := range result.hotkeys {
+			s.hotkeys[key] = struct{}{}
+		}
+		for key := range cache.classKeys {
+			s.hotkeys[key] = struct{}{}
+		}
 	}
 
-	data, err := restricter.Get(ctx, newKeys...)
+	// The data can be shared, so it has to be copied before it is filtered.
+	data := make(map[dskey.Key][]byte, len(result.data))
+	for k, v := range result.data {
+		data[k] = v
+	}
+	s.filter.filter(data)
+
+	return data, result.position, nil
+}
+
+// sharedData returns the data from the shared store.
+//
+// It first looks for the data of the permission class. If this data depends
+// on the user, that computed it, the data of the own user is used.
+func (s *subscription) sharedData(ctx context.Context, cache *restrictCache, shared *sharedStore, fingerprint string, compute func() (*sharedResult, error)) (*sharedResult, error) {
+	if cache.class != "" {
+		result, err := shared.get(ctx, sharedKey{class: cache.class, fingerprint: fingerprint}, cache.tid, compute)
+		if err != nil {
+			return nil, err
+		}
+
+		if !result.userSpecific || result.uid == cache.uid {
+			return result, nil
+		}
+	}
+
+	return shared.get(ctx, sharedKey{uid: cache.uid, fingerprint: fingerprint}, cache.tid, compute)
+}
+
+// compute updates the keysbuilder and restricts the values.
+func (s *subscription) compute(ctx context.Context, cache *restrictCache) (*sharedResult, error) {
+	getter := cache.recorder()
+
+	if err := s.kb.Update(ctx, getter); err != nil {
+		return nil, fmt.Errorf("create keys for keysbuilder: %w", err)
+	}
+
+	keys := s.kb.Keys()
+	data, err := getter.Get(ctx, keys...)
 	if err != nil {
 		return nil, fmt.Errorf("get restricted data: %w", err)
 	}
-	c.hotkeys = recorder.Keys()
 
-	c.filter.filter(data)
+	return &sharedResult{
+		keys:         keys,
+		data:         data,
+		hotkeys:      getter.Keys(),
+		position:     cache.position,
+		uid:          cache.uid,
+		userSpecific: getter.userSpecific,
+	}, nil
+}
 
-	return data, nil
+// hasHotkey returns true, if one of the given keys is a hotkey of the
+// subscription.
+func (s *subscription) hasHotkey(keys []dskey.Key) bool {
+	for _, key := range keys {
+		if _, ok := s.hotkeys[key]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 // notInSlice returns elements that are in slice a but not in b.
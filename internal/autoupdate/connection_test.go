@@ -3,12 +3,16 @@ package autoupdate_test
 import (
 	"context"
 	"errors"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
 )
@@ -456,3 +460,347 @@ func TestKeyRequestedAgain(t *testing.T) {
 		t.Errorf("Got organization_tag/2/id: %q, expected 2", v)
 	}
 }
+
+func TestConnectionSlowClient(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	for _, tt := range []struct {
+		name          string
+		maxLag        time.Duration
+		expectTooSlow bool
+	}{
+		{"coalesce changes", time.Hour, false},
+		{"too slow", time.Millisecond, true},
+		{"disabled", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+				userNameKey: []byte(`"Hello World"`),
+				userMailKey: []byte(`"hello@example.com"`),
+			})
+			go bg(shutdownCtx, oserror.Handle)
+
+			s, _ := autoupdate.New(ds, RestrictAllowed, autoupdate.WithMaxLag(tt.maxLag))
+
+			newKB := func() autoupdate.KeysBuilder {
+				kb, _ := keysbuilder.FromKeys(userNameKey.String(), userMailKey.String())
+				return kb
+			}
+
+			next, _ := s.Connect(1, newKB())()
+			if _, err := next(context.Background()); err != nil {
+				t.Fatalf("next(): %v", err)
+			}
+
+			// The watcher is used to wait until the updates are processed.
+			waitForUpdate, _ := s.Connect(1, newKB())()
+			if _, err := waitForUpdate(context.Background()); err != nil {
+				t.Fatalf("first data for watcher: %v", err)
+			}
+
+			for _, update := range []map[dskey.Key][]byte{
+				{userNameKey: []byte(`"first name"`)},
+				{userNameKey: []byte(`"second name"`), userMailKey: []byte(`"new@example.com"`)},
+			} {
+				ds.Send(update)
+				if _, err := waitForUpdate(context.Background()); err != nil {
+					t.Fatalf("waiting for update: %v", err)
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			data, err := next(context.Background())
+
+			if tt.expectTooSlow {
+				var errTyped interface{ Type() string }
+				if !errors.As(err, &errTyped) || errTyped.Type() != "too_slow" {
+					t.Fatalf("got error %v, expected too_slow", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("next(): %v", err)
+			}
+
+			expect := map[dskey.Key][]byte{
+				userNameKey: []byte(`"second name"`),
+				userMailKey: []byte(`"new@example.com"`),
+			}
+			if !reflect.DeepEqual(data, expect) {
+				t.Errorf("got %v, expected %v", data, expect)
+			}
+		})
+	}
+}
+
+func TestConnectionSharedComputation(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	var restrictCalls int32
+	restricter := func(getter datastore.Getter, uid int) datastore.Getter {
+		atomic.AddInt32(&restrictCalls, 1)
+		return RestrictAllowed(getter, uid)
+	}
+
+	s, _ := autoupdate.New(ds, restricter)
+
+	connect := func(uid int, request string) func(context.Context) (map[dskey.Key][]byte, error) {
+		kb, err := keysbuilder.ManyFromJSON(strings.NewReader(request))
+		if err != nil {
+			t.Fatalf("creating keysbuilder: %v", err)
+		}
+		next, _ := s.Connect(uid, kb)()
+		return next
+	}
+
+	first := connect(1, `[{"ids":[1],"collection":"user","fields":{"name":null}}]`)
+	second := connect(1, `[{"collection": "user", "ids": [1], "fields": {"name": null}}]`)
+	otherUser := connect(2, `[{"ids":[1],"collection":"user","fields":{"name":null}}]`)
+
+	for _, next := range []func(context.Context) (map[dskey.Key][]byte, error){first, second, otherUser} {
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		if string(data[userNameKey]) != `"Hello World"` {
+			t.Errorf("got %v, expected the user name", data)
+		}
+	}
+
+	if got := atomic.LoadInt32(&restrictCalls); got != 2 {
+		t.Errorf("restricter was called %d times, expected 2 (one time for each user)", got)
+	}
+}
+
+// fakeClasser puts users in permission classes. If userSpecific is true, all
+// restricted data depends on the user.
+type fakeClasser struct {
+	classes      map[int]string
+	userSpecific bool
+}
+
+func (c fakeClasser) PermissionClass(ctx context.Context, getter datastore.Getter, uid int) (string, error) {
+	return c.classes[uid], nil
+}
+
+func (c fakeClasser) TrackUserSpecific(ctx context.Context) (context.Context, func() bool) {
+	return ctx, func() bool { return c.userSpecific }
+}
+
+func TestConnectionSharedComputationPermissionClass(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		userSpecific bool
+		expectCalls  int32
+	}{
+		{"same data", false, 2},
+		{"user specific data", true, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdownCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+				userNameKey: []byte(`"Hello World"`),
+			})
+			go bg(shutdownCtx, oserror.Handle)
+
+			var restrictCalls int32
+			restricter := func(getter datastore.Getter, uid int) datastore.Getter {
+				atomic.AddInt32(&restrictCalls, 1)
+				return RestrictAllowed(getter, uid)
+			}
+
+			classer := fakeClasser{
+				classes:      map[int]string{1: "delegate", 2: "delegate", 3: "admin"},
+				userSpecific: tt.userSpecific,
+			}
+			s, _ := autoupdate.New(ds, restricter, autoupdate.WithPermissionClasser(classer))
+
+			for _, uid := range []int{1, 2, 3} {
+				kb, err := keysbuilder.ManyFromJSON(strings.NewReader(`[{"ids":[1],"collection":"user","fields":{"name":null}}]`))
+				if err != nil {
+					t.Fatalf("creating keysbuilder: %v", err)
+				}
+
+				next, _ := s.Connect(uid, kb)()
+				data, err := next(context.Background())
+				if err != nil {
+					t.Fatalf("next(): %v", err)
+				}
+
+				if string(data[userNameKey]) != `"Hello World"` {
+					t.Errorf("got %v, expected the user name", data)
+				}
+			}
+
+			if got := atomic.LoadInt32(&restrictCalls); got != tt.expectCalls {
+				t.Errorf("restricter was called %d times, expected %d", got, tt.expectCalls)
+			}
+		})
+	}
+}
+
+func TestConnectionOnlyWokenByHotkeys(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+		userMailKey: []byte(`"hello@example.com"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	// The connection is not too slow, if only other keys change.
+	s, _ := autoupdate.New(ds, RestrictAllowed, autoupdate.WithMaxLag(time.Millisecond))
+
+	nameKB, _ := keysbuilder.FromKeys(userNameKey.String())
+	next, _ := s.Connect(1, nameKB)()
+	if _, err := next(context.Background()); err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	mailKB, _ := keysbuilder.FromKeys(userMailKey.String())
+	waitForMail, _ := s.Connect(1, mailKB)()
+	if _, err := waitForMail(context.Background()); err != nil {
+		t.Fatalf("first data for watcher: %v", err)
+	}
+
+	type result struct {
+		data map[dskey.Key][]byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := next(context.Background())
+		done <- result{data, err}
+	}()
+
+	ds.Send(map[dskey.Key][]byte{userMailKey: []byte(`"new@example.com"`)})
+	if _, err := waitForMail(context.Background()); err != nil {
+		t.Fatalf("waiting for update: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	select {
+	case r := <-done:
+		t.Fatalf("next() returned after an update of an other key: %v, %v", r.data, r.err)
+	default:
+	}
+
+	ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)})
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("next(): %v", r.err)
+	}
+
+	expect := map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)}
+	if !reflect.DeepEqual(r.data, expect) {
+		t.Errorf("got %v, expected %v", r.data, expect)
+	}
+}
+
+func TestConnectionCoalesceWindow(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+		userMailKey: []byte(`"hello@example.com"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed, autoupdate.WithCoalesceWindow(100*time.Millisecond))
+
+	kb, _ := keysbuilder.FromKeys(userNameKey.String(), userMailKey.String())
+	next, _ := s.Connect(1, kb)()
+	if _, err := next(context.Background()); err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)})
+	ds.Send(map[dskey.Key][]byte{userMailKey: []byte(`"new@example.com"`)})
+
+	data, err := next(context.Background())
+	if err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	expect := map[dskey.Key][]byte{
+		userNameKey: []byte(`"new name"`),
+		userMailKey: []byte(`"new@example.com"`),
+	}
+	if !reflect.DeepEqual(data, expect) {
+		t.Errorf("got %v, expected both changes in one message: %v", data, expect)
+	}
+}
+
+func TestConnectionOtherMeeting(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(dsmock.YAMLData(`---
+	motion:
+		1:
+			meeting_id: 1
+			title: first motion
+		2:
+			meeting_id: 2
+			title: other motion
+	`))
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+
+	kb, _ := keysbuilder.FromKeys("motion/1/title")
+	next, _ := s.Connect(1, kb)()
+	if _, err := next(context.Background()); err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	otherKB, _ := keysbuilder.FromKeys("motion/2/title")
+	waitForOther, _ := s.Connect(1, otherKB)()
+	if _, err := waitForOther(context.Background()); err != nil {
+		t.Fatalf("first data for watcher: %v", err)
+	}
+
+	done := make(chan map[dskey.Key][]byte, 1)
+	go func() {
+		data, _ := next(context.Background())
+		done <- data
+	}()
+
+	ds.Send(dsmock.YAMLData(`motion/2/title: changed other motion`))
+	if _, err := waitForOther(context.Background()); err != nil {
+		t.Fatalf("waiting for update: %v", err)
+	}
+
+	select {
+	case data := <-done:
+		t.Fatalf("next() returned after an update in an other meeting: %v", data)
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	ds.Send(dsmock.YAMLData(`motion/1/title: changed motion`))
+
+	data := <-done
+	if got := string(data[dskey.MustKey("motion/1/title")]); got != `"changed motion"` {
+		t.Errorf("got %s, expected \"changed motion\"", got)
+	}
+}
@@ -0,0 +1,73 @@
+package autoupdate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// DataDiff is the difference of the data of a keys request between two
+// positions.
+type DataDiff struct {
+	// Added are the keys, that only exist at the second position, with their
+	// new values.
+	Added map[dskey.Key][]byte
+
+	// Removed are the keys, that only exist at the first position, with their
+	// old values.
+	Removed map[dskey.Key][]byte
+
+	// Changed are the keys, that exist at both positions with different
+	// values.
+	Changed map[dskey.Key]ValueChange
+}
+
+// ValueChange is the value of a key at two positions.
+type ValueChange struct {
+	From []byte
+	To   []byte
+}
+
+// Diff returns the difference of the data for the keysbuilder between the
+// positions from and to.
+//
+// The keysbuilder is evaluated at both positions. So keys from relations can
+// be added or removed. The data is restricted with the same rules as
+// SingleData with a position. Both positions have to be greater then 0.
+func (a *Autoupdate) Diff(ctx context.Context, userID int, kb KeysBuilder, from, to int) (DataDiff, error) {
+	fromData, err := a.SingleData(ctx, userID, kb, from)
+	if err != nil {
+		return DataDiff{}, fmt.Errorf("getting data at position %d: %w", from, err)
+	}
+
+	toData, err := a.SingleData(ctx, userID, kb, to)
+	if err != nil {
+		return DataDiff{}, fmt.Errorf("getting data at position %d: %w", to, err)
+	}
+
+	diff := DataDiff{
+		Added:   make(map[dskey.Key][]byte),
+		Removed: make(map[dskey.Key][]byte),
+		Changed: make(map[dskey.Key]ValueChange),
+	}
+
+	for key, value := range toData {
+		old, ok := fromData[key]
+		switch {
+		case !ok:
+			diff.Added[key] = value
+		case !bytes.Equal(old, value):
+			diff.Changed[key] = ValueChange{From: old, To: value}
+		}
+	}
+
+	for key, value := range fromData {
+		if _, ok := toData[key]; !ok {
+			diff.Removed[key] = value
+		}
+	}
+
+	return diff, nil
+}
@@ -57,3 +57,17 @@ func (f *filter) empty() bool {
 func (f *filter) delete(k dskey.Key) {
 	delete(f.history, k)
 }
+
+// clone returns a copy of the filter, that can be used independently.
+func (f *filter) clone() filter {
+	c := filter{hasher: f.hasher}
+	if f.history == nil {
+		return c
+	}
+
+	c.history = make(map[dskey.Key]uint64, len(f.history))
+	for k, v := range f.history {
+		c.history[k] = v
+	}
+	return c
+}
@@ -0,0 +1,98 @@
+package autoupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict/collection"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict/perm"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// historyPermission checks, if a user can see the history information of
+// fqids.
+//
+// It remembers the permissions of the user, so many fqids of the same meeting
+// can be checked without fetching the permissions again.
+type historyPermission struct {
+	ds  *dsfetch.Fetch
+	uid int
+
+	// organization is the result of the check for the organization
+	// management level. It is nil, until it was checked.
+	organization *bool
+	meetings     map[int]bool
+}
+
+func newHistoryPermission(getter dsfetch.Getter, uid int) *historyPermission {
+	return &historyPermission{
+		ds:       dsfetch.New(getter),
+		uid:      uid,
+		meetings: make(map[int]bool),
+	}
+}
+
+// check returns nil, if the user can see the history information of the
+// fqid.
+//
+// Objects in a meeting need the permission motion.can_see_history in the
+// meeting. Other objects need the organization management level
+// can_manage_organization.
+func (h *historyPermission) check(ctx context.Context, fqid string) error {
+	if !reValidKeys.MatchString(fqid) {
+		// TODO Client Error
+		return invalidInputError{fmt.Sprintf("fqid %s is invalid", fqid)}
+	}
+
+	coll, rawID, _ := strings.Cut(fqid, "/")
+	id, _ := strconv.Atoi(rawID)
+
+	meetingID, hasMeeting, err := collection.Collection(coll).MeetingID(ctx, h.ds, id)
+	if err != nil {
+		var errNotExist dsfetch.DoesNotExistError
+		if errors.As(err, &errNotExist) {
+			// TODO Client Error
+			return notExistError{dskey.Key(errNotExist)}
+		}
+		return fmt.Errorf("getting meeting id for collection %s id %d: %w", coll, id, err)
+	}
+
+	var allowed bool
+	if !hasMeeting {
+		if h.organization == nil {
+			hasOML, err := perm.HasOrganizationManagementLevel(ctx, h.ds, h.uid, perm.OMLCanManageOrganization)
+			if err != nil {
+				return fmt.Errorf("getting organization management level: %w", err)
+			}
+			h.organization = &hasOML
+		}
+		allowed = *h.organization
+	} else {
+		var ok bool
+		allowed, ok = h.meetings[meetingID]
+		if !ok {
+			p, err := perm.New(ctx, h.ds, h.uid, meetingID)
+			if err != nil {
+				return fmt.Errorf("getting meeting permissions: %w", err)
+			}
+			allowed = p.Has(perm.MeetingCanSeeHistory)
+			h.meetings[meetingID] = allowed
+		}
+	}
+
+	if !allowed {
+		// TODO Client Error
+		return permissionDeniedError{fmt.Errorf("you are not allowed to use history information on %s", fqid)}
+	}
+	return nil
+}
+
+// HistoryFilter selects the entries of the history information.
+//
+// The zero value selects all entries.
+type HistoryFilter = datastore.HistoryFilter
@@ -0,0 +1,205 @@
+package autoupdate
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/ostcar/topic"
+)
+
+// hotkeyIndex knows for each key, which connections are waiting for it.
+//
+// When new data is published, only the connections are woken, where one of
+// the hotkeys changed. Without the index, every connection would have to
+// check the changed keys on its own.
+//
+// A connection is only in the index, while it waits for new data.
+//
+// The index is partitioned by meetings. Keys of objects without a saved
+// meeting are in the global partition 0. Changes in one meeting only lock the
+// partition of the meeting, so the partitions of other meetings can be used at
+// the same time.
+//
+// The data is published to the topic with the partitions of the keys locked.
+// So a connection that is in the index gets woken for every topic id after the
+// one returned by register.
+type hotkeyIndex struct {
+	topic    *topic.Topic[dskey.Key]
+	meetings *meetingResolver
+
+	// partitions is a map from the meeting id to its *hotkeyPartition.
+	partitions sync.Map
+
+	// pruneMu is locked for reading, while the partitions are used. prune
+	// locks it for writing, so the saved meetings do not change, while a
+	// connection is registered or data is published.
+	pruneMu sync.RWMutex
+}
+
+// hotkeyPartition is the part of the index for one meeting.
+type hotkeyPartition struct {
+	mu          sync.Mutex
+	connections map[dskey.Key]map[*connection]struct{}
+}
+
+func newHotkeyIndex(t *topic.Topic[dskey.Key], meetings *meetingResolver) *hotkeyIndex {
+	return &hotkeyIndex{
+		topic:    t,
+		meetings: meetings,
+	}
+}
+
+// partition returns the partition of a meeting.
+func (idx *hotkeyIndex) partition(meetingID int) *hotkeyPartition {
+	if part, ok := idx.partitions.Load(meetingID); ok {
+		return part.(*hotkeyPartition)
+	}
+
+	part, _ := idx.partitions.LoadOrStore(meetingID, &hotkeyPartition{connections: make(map[dskey.Key]map[*connection]struct{})})
+	return part.(*hotkeyPartition)
+}
+
+// lock sorts the keys by their partition and locks the partitions. The
+// resolved meetings are saved before.
+//
+// Meetings are only saved with the global partition locked. So keys without a
+// saved meeting are read again, after the global partition is locked. This
+// makes sure, that a key is in the same partition for all calls, that run at
+// the same time. Keys with a saved meeting do not need the global partition.
+//
+// The partitions are locked in the order of the meeting ids, so two calls can
+// not block each other.
+func (idx *hotkeyIndex) lock(keys []dskey.Key, resolved map[meetingResolverKey]int) (map[*hotkeyPartition][]dskey.Key, func()) {
+	idx.pruneMu.RLock()
+
+	byMeeting := make(map[int][]dskey.Key)
+	var unknown []dskey.Key
+	for _, key := range keys {
+		meetingID := idx.meetings.meetingID(key.Collection, key.ID)
+		if meetingID == 0 {
+			unknown = append(unknown, key)
+			continue
+		}
+		byMeeting[meetingID] = append(byMeeting[meetingID], key)
+	}
+
+	var parts []*hotkeyPartition
+	byPartition := make(map[*hotkeyPartition][]dskey.Key)
+	if len(unknown) > 0 || len(resolved) > 0 {
+		global := idx.partition(0)
+		global.mu.Lock()
+		parts = append(parts, global)
+
+		idx.meetings.save(resolved)
+		for _, key := range unknown {
+			meetingID := idx.meetings.meetingID(key.Collection, key.ID)
+			byMeeting[meetingID] = append(byMeeting[meetingID], key)
+		}
+		byPartition[global] = byMeeting[0]
+	}
+
+	meetingIDs := make([]int, 0, len(byMeeting))
+	for meetingID := range byMeeting {
+		if meetingID != 0 {
+			meetingIDs = append(meetingIDs, meetingID)
+		}
+	}
+	sort.Ints(meetingIDs)
+
+	for _, meetingID := range meetingIDs {
+		part := idx.partition(meetingID)
+		part.mu.Lock()
+		parts = append(parts, part)
+		byPartition[part] = byMeeting[meetingID]
+	}
+
+	unlock := func() {
+		for _, part := range parts {
+			part.mu.Unlock()
+		}
+		idx.pruneMu.RUnlock()
+	}
+	return byPartition, unlock
+}
+
+// prune removes the saved meetings of all objects, that no connection waits
+// for.
+func (idx *hotkeyIndex) prune() {
+	idx.pruneMu.Lock()
+	defer idx.pruneMu.Unlock()
+
+	keep := make(map[meetingResolverKey]struct{})
+	idx.partitions.Range(func(_, value interface{}) bool {
+		part := value.(*hotkeyPartition)
+		part.mu.Lock()
+		for key := range part.connections {
+			keep[meetingResolverKey{collection: key.Collection, id: key.ID}] = struct{}{}
+		}
+		part.mu.Unlock()
+		return true
+	})
+
+	idx.meetings.forget(keep)
+}
+
+// register adds a connection for the given keys. The resolved meetings of the
+// keys are saved. It returns the last topic id, the connection will not be
+// woken for.
+func (idx *hotkeyIndex) register(c *connection, keys []dskey.Key, resolved map[meetingResolverKey]int) uint64 {
+	byPartition, unlock := idx.lock(keys, resolved)
+	defer unlock()
+
+	for part, keys := range byPartition {
+		for _, key := range keys {
+			conns, ok := part.connections[key]
+			if !ok {
+				conns = make(map[*connection]struct{})
+				part.connections[key] = conns
+			}
+			conns[c] = struct{}{}
+		}
+	}
+	return idx.topic.LastID()
+}
+
+// unregister removes a connection for the given keys. It returns the last
+// topic id, the connection was woken for, if one of the keys changed.
+func (idx *hotkeyIndex) unregister(c *connection, keys []dskey.Key) uint64 {
+	byPartition, unlock := idx.lock(keys, nil)
+	defer unlock()
+
+	for part, keys := range byPartition {
+		for _, key := range keys {
+			conns := part.connections[key]
+			delete(conns, c)
+			if len(conns) == 0 {
+				delete(part.connections, key)
+			}
+		}
+	}
+	return idx.topic.LastID()
+}
+
+// publish adds the keys to the topic and wakes all connections, that wait for
+// one of them. Returns the new topic id.
+func (idx *hotkeyIndex) publish(keys []dskey.Key) uint64 {
+	byPartition, unlock := idx.lock(keys, nil)
+	defer unlock()
+
+	tid := idx.topic.Publish(keys...)
+
+	affected := make(map[*connection][]dskey.Key)
+	for part, keys := range byPartition {
+		for _, key := range keys {
+			for c := range part.connections[key] {
+				affected[c] = append(affected[c], key)
+			}
+		}
+	}
+
+	for c, keys := range affected {
+		c.wakeUp(keys)
+	}
+	return tid
+}
@@ -0,0 +1,80 @@
+package autoupdate
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// publishLog remembers, when each topic id was published.
+//
+// It is used to find out, how long a connection did not fetch the new data.
+type publishLog struct {
+	mu      sync.Mutex
+	entries []publishEntry
+}
+
+type publishEntry struct {
+	tid  uint64
+	time time.Time
+}
+
+// add saves the time of a topic id. The ids have to be added in order.
+func (l *publishLog) add(tid uint64, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, publishEntry{tid: tid, time: t})
+}
+
+// lag returns the time since the first topic id after tid was published.
+//
+// Returns 0, if there is no newer topic id.
+func (l *publishLog) lag(tid uint64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idx := sort.Search(len(l.entries), func(i int) bool {
+		return l.entries[i].tid > tid
+	})
+
+	if idx == len(l.entries) {
+		return 0
+	}
+	return now.Sub(l.entries[idx].time)
+}
+
+// prune removes all entries, that are older then the given time.
+func (l *publishLog) prune(before time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idx := sort.Search(len(l.entries), func(i int) bool {
+		return !l.entries[i].time.Before(before)
+	})
+
+	l.entries = append(l.entries[:0:0], l.entries[idx:]...)
+}
+
+// tooSlowError is returned, when a client does not fetch the new data fast
+// enough.
+type tooSlowError struct {
+	lag time.Duration
+}
+
+func (e tooSlowError) Error() string {
+	if e.lag == 0 {
+		return "the connection is too slow. The data it is waiting for was already removed"
+	}
+	return fmt.Sprintf("the connection is too slow. It is %s behind", e.lag.Round(time.Second))
+}
+
+func (e tooSlowError) Type() string {
+	return "too_slow"
+}
+
+// Retry tells the client, that it can reconnect.
+func (e tooSlowError) Retry() bool {
+	return true
+}
@@ -0,0 +1,101 @@
+package autoupdate
+
+import (
+	"context"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// Multiplexer is a connection with many subscriptions. It has to be created
+// with Autoupdate.Multiplex().
+//
+// All subscriptions wait for the same updates and share the work to restrict
+// the data.
+//
+// Subscribe and Unsubscribe can be called at the same time as the function
+// returned by Next.
+type Multiplexer struct {
+	conn *connection
+}
+
+// Subscribe adds a subscription with an id. If there is already a
+// subscription with the id, it is replaced.
+//
+// The data for a new subscription is returned by the next call to Next.
+func (m *Multiplexer) Subscribe(id string, kb KeysBuilder) {
+	m.conn.subscribe(id, kb)
+}
+
+// Resume adds a subscription like Subscribe, but the subscription can be
+// resumed on another connection.
+//
+// The argument request has to identify the request of the subscription, for
+// example the json of the keys request. It is used to find the state of the
+// subscription, when it is resumed.
+//
+// The argument since is the topic id of the last data, that the client has
+// received for the subscription on a closed connection. If the subscription
+// can be resumed at this id, the next call to Next only returns the values,
+// that have changed since then. This only works, if the connection was closed
+// less then 10 minutes ago. Otherwise, or if since is 0, all data is returned.
+func (m *Multiplexer) Resume(id string, kb KeysBuilder, request string, since uint64) {
+	m.conn.resume(id, kb, request, since)
+}
+
+// Change replaces the keysbuilder of the subscription with the id.
+//
+// In difference to Subscribe, the data, that was already returned for the
+// subscription, is not returned again. The next call to Next only returns the
+// values, that are new or have changed. Keys, that are not requested anymore,
+// are forgotten. If they are requested again later, they are returned again.
+//
+// If there is no subscription with the id, Change works like Subscribe.
+func (m *Multiplexer) Change(id string, kb KeysBuilder) {
+	m.conn.change(id, kb)
+}
+
+// Unsubscribe removes the subscription with the id.
+func (m *Multiplexer) Unsubscribe(id string) {
+	m.conn.unsubscribe(id)
+}
+
+// MultiplexData is the data returned from a Multiplexer.
+type MultiplexData struct {
+	// ID is the topic id of the data. It can be used to resume the
+	// subscriptions on another connection.
+	ID uint64
+
+	// Position is the datastore position of the data. The data contains at
+	// least all changes until this position.
+	Position int
+
+	// Subscriptions contains the data for each subscription id.
+	Subscriptions map[string]map[dskey.Key][]byte
+
+	// Errors contains the error for each subscription id, where the data
+	// could not be created. These subscriptions are removed. The other
+	// subscriptions keep running.
+	Errors map[string]error
+}
+
+// Next works like the DataProvider returned from Autoupdate.Connect(), but it
+// returns the data for all subscriptions.
+//
+// The returned function does not block, if there is a new subscription. In
+// this case, the data for the new subscription can be empty. In all other
+// cases, it blocks until there is new data for at least one subscription.
+// Subscriptions without new data are not in the returned data.
+//
+// An error of one subscription, for example an invalid keys request, is
+// returned in MultiplexData.Errors. The returned error is only set, if the
+// whole connection fails.
+func (m *Multiplexer) Next() (func(ctx context.Context) (MultiplexData, error), bool) {
+	return func(ctx context.Context) (MultiplexData, error) {
+		data, errs, err := m.conn.next(ctx)
+		if err != nil {
+			return MultiplexData{}, err
+		}
+
+		return MultiplexData{ID: m.conn.sentTid, Position: m.conn.position, Subscriptions: data, Errors: errs}, nil
+	}, true
+}
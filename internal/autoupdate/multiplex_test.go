@@ -0,0 +1,357 @@
+package autoupdate_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
+)
+
+func TestMultiplex(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+		userMailKey: []byte(`"hello@example.com"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+	m := s.Multiplex(1)
+	next, _ := m.Next()
+
+	nameKB, _ := keysbuilder.FromKeys(userNameKey.String())
+	mailKB, _ := keysbuilder.FromKeys(userMailKey.String())
+
+	t.Run("first data", func(t *testing.T) {
+		m.Subscribe("name", nameKB)
+		m.Subscribe("mail", mailKB)
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		if got := string(data.Subscriptions["name"][userNameKey]); got != `"Hello World"` {
+			t.Errorf("subscription name has value %s, expected \"Hello World\"", got)
+		}
+
+		if got := string(data.Subscriptions["mail"][userMailKey]); got != `"hello@example.com"` {
+			t.Errorf("subscription mail has value %s, expected \"hello@example.com\"", got)
+		}
+	})
+
+	t.Run("update only one subscription", func(t *testing.T) {
+		ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)})
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		if len(data.Subscriptions) != 1 {
+			t.Errorf("got data for %d subscriptions, expected 1", len(data.Subscriptions))
+		}
+
+		if got := string(data.Subscriptions["name"][userNameKey]); got != `"new name"` {
+			t.Errorf("subscription name has value %s, expected \"new name\"", got)
+		}
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		m.Unsubscribe("name")
+		ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"other name"`)})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		isBlocking := blocking(func() {
+			defer close(done)
+			next(ctx)
+		})
+		cancel()
+		<-done
+
+		if !isBlocking {
+			t.Errorf("next() did not block after unsubscribe")
+		}
+	})
+
+	t.Run("subscribe while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		done := make(chan autoupdate.MultiplexData)
+		go func() {
+			data, _ := next(ctx)
+			done <- data
+		}()
+
+		m.Subscribe("name2", nameKB)
+
+		data := <-done
+		if got := string(data.Subscriptions["name2"][userNameKey]); got != `"other name"` {
+			t.Errorf("subscription name2 has value %s, expected \"other name\"", got)
+		}
+	})
+}
+
+func TestMultiplexChange(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+		userMailKey: []byte(`"hello@example.com"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+	m := s.Multiplex(1)
+	next, _ := m.Next()
+
+	nameKB, _ := keysbuilder.FromKeys(userNameKey.String())
+	m.Subscribe("sub", nameKB)
+	if _, err := next(context.Background()); err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	t.Run("add key", func(t *testing.T) {
+		bothKB, _ := keysbuilder.FromKeys(userNameKey.String(), userMailKey.String())
+		m.Change("sub", bothKB)
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		expect := map[dskey.Key][]byte{userMailKey: []byte(`"hello@example.com"`)}
+		if !reflect.DeepEqual(data.Subscriptions["sub"], expect) {
+			t.Errorf("got %v, expected %v", data.Subscriptions["sub"], expect)
+		}
+	})
+
+	t.Run("remove key and add it again", func(t *testing.T) {
+		m.Change("sub", nameKB)
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		if len(data.Subscriptions["sub"]) != 0 {
+			t.Errorf("got %v, expected no data", data.Subscriptions["sub"])
+		}
+
+		bothKB, _ := keysbuilder.FromKeys(userNameKey.String(), userMailKey.String())
+		m.Change("sub", bothKB)
+
+		data, err = next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		expect := map[dskey.Key][]byte{userMailKey: []byte(`"hello@example.com"`)}
+		if !reflect.DeepEqual(data.Subscriptions["sub"], expect) {
+			t.Errorf("got %v, expected %v", data.Subscriptions["sub"], expect)
+		}
+	})
+}
+
+func TestMultiplexResume(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+		userMailKey: []byte(`"hello@example.com"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+
+	newKB := func() autoupdate.KeysBuilder {
+		kb, _ := keysbuilder.FromKeys(userNameKey.String(), userMailKey.String())
+		return kb
+	}
+
+	m := s.Multiplex(1)
+	next, _ := m.Next()
+	m.Resume("sub", newKB(), "my request", 0)
+	data, err := next(context.Background())
+	if err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+	since := data.ID
+
+	// Close the connection.
+	closedCtx, closeConn := context.WithCancel(context.Background())
+	closeConn()
+	if _, err := next(closedCtx); err == nil {
+		t.Fatalf("next() with closed context did not return an error")
+	}
+
+	// The watcher is used to wait until the update is processed.
+	watcher := s.Multiplex(1)
+	waitForUpdate, _ := watcher.Next()
+	watcher.Subscribe("", newKB())
+	if _, err := waitForUpdate(context.Background()); err != nil {
+		t.Fatalf("first data for watcher: %v", err)
+	}
+
+	ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)})
+	if _, err := waitForUpdate(context.Background()); err != nil {
+		t.Fatalf("waiting for update: %v", err)
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		m := s.Multiplex(1)
+		next, _ := m.Next()
+		m.Resume("sub", newKB(), "my request", since)
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		expect := map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)}
+		if !reflect.DeepEqual(data.Subscriptions["sub"], expect) {
+			t.Errorf("got %v, expected %v", data.Subscriptions["sub"], expect)
+		}
+	})
+
+	t.Run("resume with other request", func(t *testing.T) {
+		m := s.Multiplex(1)
+		next, _ := m.Next()
+		m.Resume("sub", newKB(), "other request", since)
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		if got := len(data.Subscriptions["sub"]); got != 2 {
+			t.Errorf("got %d values, expected all 2", got)
+		}
+	})
+
+	t.Run("resume with other user", func(t *testing.T) {
+		m := s.Multiplex(2)
+		next, _ := m.Next()
+		m.Resume("sub", newKB(), "my request", since)
+
+		data, err := next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+
+		if got := len(data.Subscriptions["sub"]); got != 2 {
+			t.Errorf("got %d values, expected all 2", got)
+		}
+	})
+}
+
+func TestMultiplexPosition(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+	m := s.Multiplex(1)
+	next, _ := m.Next()
+
+	kb, _ := keysbuilder.FromKeys(userNameKey.String())
+	m.Subscribe("name", kb)
+
+	data, err := next(context.Background())
+	if err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	if data.Position != 0 {
+		t.Errorf("first data has position %d, expected 0", data.Position)
+	}
+
+	ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)})
+	ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"newer name"`)})
+
+	for data.Position < 2 {
+		data, err = next(context.Background())
+		if err != nil {
+			t.Fatalf("next(): %v", err)
+		}
+	}
+
+	if got := string(data.Subscriptions["name"][userNameKey]); got != `"newer name"` {
+		t.Errorf("got %s at position %d, expected \"newer name\"", got, data.Position)
+	}
+}
+
+func TestMultiplexSubscriptionError(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userMailKey := dskey.MustKey("user/1/email")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		userNameKey: []byte(`"Hello World"`),
+		userMailKey: []byte(`"hello@example.com"`),
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	s, _ := autoupdate.New(ds, RestrictAllowed)
+	m := s.Multiplex(1)
+	next, _ := m.Next()
+
+	nameKB, _ := keysbuilder.FromKeys(userNameKey.String())
+	tooBigKB, _ := keysbuilder.FromKeys(userNameKey.String(), userMailKey.String())
+	tooBigKB.SetLimits(keysbuilder.Limits{MaxKeys: 1})
+
+	m.Subscribe("name", nameKB)
+	m.Subscribe("too_big", tooBigKB)
+
+	data, err := next(context.Background())
+	if err != nil {
+		t.Fatalf("next(): %v", err)
+	}
+
+	var errLimit keysbuilder.LimitError
+	if !errors.As(data.Errors["too_big"], &errLimit) {
+		t.Errorf("subscription too_big has error %v, expected a LimitError", data.Errors["too_big"])
+	}
+
+	if got := string(data.Subscriptions["name"][userNameKey]); got != `"Hello World"` {
+		t.Errorf("subscription name has value %s, expected \"Hello World\"", got)
+	}
+
+	ds.Send(map[dskey.Key][]byte{userNameKey: []byte(`"new name"`)})
+
+	data, err = next(context.Background())
+	if err != nil {
+		t.Fatalf("second next(): %v", err)
+	}
+
+	if len(data.Errors) != 0 {
+		t.Errorf("got errors %v after the update, expected none", data.Errors)
+	}
+
+	if got := string(data.Subscriptions["name"][userNameKey]); got != `"new name"` {
+		t.Errorf("subscription name has value %s, expected \"new name\"", got)
+	}
+}
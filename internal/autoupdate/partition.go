@@ -0,0 +1,129 @@
+package autoupdate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict/collection"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// resolveTimeout is the time a connection waits to look up the meetings of its
+// hotkeys. Objects that are not resolved in time use the global partition.
+const resolveTimeout = time.Second
+
+// meetingResolver finds the meeting of an object. It is used to partition the
+// hotkey index.
+//
+// The same key has to be in the same partition, when a connection waits for it
+// and when it is published. So the meeting of an object is saved and does not
+// change until the object is removed with forget.
+//
+// The meetings are looked up by the connections with resolve before they wait
+// for their hotkeys and saved, when the connection is registered. meetingID
+// never blocks and does not save anything. So publishing does not wait for the
+// datastore and the saved meetings only grow with the keys, that connections
+// wait for. Objects, that are not saved, use the global partition 0.
+type meetingResolver struct {
+	getter dsfetch.Getter
+
+	mu       sync.Mutex
+	meetings map[meetingResolverKey]int
+}
+
+type meetingResolverKey struct {
+	collection string
+	id         int
+}
+
+func newMeetingResolver(getter dsfetch.Getter) *meetingResolver {
+	return &meetingResolver{
+		getter:   getter,
+		meetings: make(map[meetingResolverKey]int),
+	}
+}
+
+// resolve looks up the meetings of the objects of the keys, that are not known
+// yet. The meetings are returned and not saved.
+//
+// It stops after resolveTimeout or when the context is done. Objects without a
+// meeting or that can not be found are not returned, so they use the global
+// partition.
+func (r *meetingResolver) resolve(ctx context.Context, keys []dskey.Key) map[meetingResolverKey]int {
+	var missing []meetingResolverKey
+	seen := make(map[meetingResolverKey]struct{})
+	r.mu.Lock()
+	for _, key := range keys {
+		rk := meetingResolverKey{collection: key.Collection, id: key.ID}
+		if _, ok := r.meetings[rk]; ok {
+			continue
+		}
+		if _, ok := seen[rk]; ok {
+			continue
+		}
+		seen[rk] = struct{}{}
+		missing = append(missing, rk)
+	}
+	r.mu.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	resolved := make(map[meetingResolverKey]int)
+	ds := dsfetch.New(r.getter)
+	for _, rk := range missing {
+		meetingID, hasMeeting, err := collection.Collection(rk.collection).MeetingID(ctx, ds, rk.id)
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err != nil || !hasMeeting || meetingID == 0 {
+			// Use the global partition. It is not important, that this is
+			// the right partition, but that it is always the same.
+			continue
+		}
+		resolved[rk] = meetingID
+	}
+	return resolved
+}
+
+// save saves the meetings of objects, that are not saved yet.
+//
+// Another goroutine could have saved an object in the meantime. Its meeting is
+// not changed.
+func (r *meetingResolver) save(meetings map[meetingResolverKey]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, meetingID := range meetings {
+		if _, ok := r.meetings[key]; !ok {
+			r.meetings[key] = meetingID
+		}
+	}
+}
+
+// meetingID returns the saved meeting of the object or 0, if it is not saved.
+func (r *meetingResolver) meetingID(coll string, id int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.meetings[meetingResolverKey{collection: coll, id: id}]
+}
+
+// forget removes all objects, that are not in the given set.
+func (r *meetingResolver) forget(keep map[meetingResolverKey]struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.meetings {
+		if _, ok := keep[key]; !ok {
+			delete(r.meetings, key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package autoupdate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
+	"github.com/ostcar/topic"
+)
+
+// blockingGetter blocks until the context is done.
+type blockingGetter struct{}
+
+func (blockingGetter) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestHotkeyIndexPublishDoesNotBlock(t *testing.T) {
+	idx := newHotkeyIndex(topic.New[dskey.Key](), newMeetingResolver(blockingGetter{}))
+
+	done := make(chan struct{})
+	go func() {
+		idx.publish([]dskey.Key{dskey.MustKey("motion/1/title")})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("publish waits for the datastore")
+	}
+}
+
+func TestMeetingResolverResolveStops(t *testing.T) {
+	r := newMeetingResolver(blockingGetter{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.resolve(ctx, []dskey.Key{dskey.MustKey("motion/1/title")})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("resolve does not stop, when the context is done")
+	}
+}
+
+func TestHotkeyIndexPrune(t *testing.T) {
+	r := newMeetingResolver(dsmock.Stub(dsmock.YAMLData(`---
+	motion/1/meeting_id: 1
+	motion/2/meeting_id: 2
+	`)))
+	idx := newHotkeyIndex(topic.New[dskey.Key](), r)
+
+	waiting := dskey.MustKey("motion/1/title")
+	published := dskey.MustKey("motion/2/title")
+
+	idx.register(new(connection), []dskey.Key{waiting}, r.resolve(context.Background(), []dskey.Key{waiting}))
+
+	// A connection, that does not wait anymore.
+	gone := new(connection)
+	idx.register(gone, []dskey.Key{published}, r.resolve(context.Background(), []dskey.Key{published}))
+	idx.unregister(gone, []dskey.Key{published})
+
+	idx.prune()
+
+	if _, ok := r.meetings[meetingResolverKey{collection: "motion", id: 1}]; !ok {
+		t.Errorf("motion/1 was removed, but a connection waits for it")
+	}
+
+	if _, ok := r.meetings[meetingResolverKey{collection: "motion", id: 2}]; ok {
+		t.Errorf("motion/2 was not removed")
+	}
+}
+
+func TestHotkeyIndexPublishDoesNotSaveMeetings(t *testing.T) {
+	r := newMeetingResolver(dsmock.Stub(dsmock.YAMLData(`---
+	motion/1/meeting_id: 1
+	`)))
+	idx := newHotkeyIndex(topic.New[dskey.Key](), r)
+
+	idx.publish([]dskey.Key{dskey.MustKey("motion/1/title"), dskey.MustKey("motion/404/title")})
+
+	if len(r.meetings) != 0 {
+		t.Errorf("publish saved the meetings %v, expected none", r.meetings)
+	}
+}
+
+func TestHotkeyIndexMeetingWithoutGlobalLock(t *testing.T) {
+	r := newMeetingResolver(dsmock.Stub(dsmock.YAMLData(`---
+	motion/1/meeting_id: 1
+	`)))
+	idx := newHotkeyIndex(topic.New[dskey.Key](), r)
+
+	key := dskey.MustKey("motion/1/title")
+	idx.register(new(connection), []dskey.Key{key}, r.resolve(context.Background(), []dskey.Key{key}))
+
+	global := idx.partition(0)
+	global.mu.Lock()
+	defer global.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		idx.publish([]dskey.Key{key})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("publish of a key with a meeting waits for the global partition")
+	}
+}
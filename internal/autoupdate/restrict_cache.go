@@ -0,0 +1,156 @@
+package autoupdate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsrecorder"
+)
+
+// restrictCache restricts keys for a user and remembers the result.
+//
+// It is used to share the restricted values between the subscriptions of a
+// connection. It is only valid for one update, since it does not get
+// invalidated, when the data changes.
+type restrictCache struct {
+	getter     datastore.Getter
+	restricter RestrictMiddleware
+	classer    PermissionClasser
+	uid        int
+
+	// class is the permission class of the user. It is empty, if there is no
+	// classer or the class could not be created. classKeys are the keys, that
+	// where needed to create the class.
+	class     string
+	classKeys map[dskey.Key]struct{}
+
+	// tid and position describe the state of the datastore, when the cache
+	// was created. The data is at least at this state.
+	tid      uint64
+	position int
+
+	values map[dskey.Key][]byte
+
+	// batch is the index in deps for each key in values.
+	batch map[dskey.Key]int
+
+	// deps contains for each call to the restricter all keys, that where
+	// needed to restrict the values.
+	deps []map[dskey.Key]struct{}
+
+	// specific is true for each call to the restricter, that returned data
+	// depending on the user id.
+	specific []bool
+}
+
+func newRestrictCache(getter datastore.Getter, restricter RestrictMiddleware, classer PermissionClasser, uid int) *restrictCache {
+	return &restrictCache{
+		getter:     getter,
+		restricter: restricter,
+		classer:    classer,
+		uid:        uid,
+		values:     make(map[dskey.Key][]byte),
+		batch:      make(map[dskey.Key]int),
+	}
+}
+
+// loadClass sets the permission class of the user.
+//
+// If it fails, the class stays empty and the data is only shared with
+// connections of the same user.
+func (c *restrictCache) loadClass(ctx context.Context) {
+	if c.classer == nil {
+		return
+	}
+
+	recorder := dsrecorder.New(c.getter)
+	class, err := c.classer.PermissionClass(ctx, recorder, c.uid)
+	if err != nil {
+		return
+	}
+
+	c.class = class
+	c.classKeys = recorder.Keys()
+}
+
+// recorder returns a getter that uses the cache and records all keys, that
+// where needed to restrict the requested values.
+func (c *restrictCache) recorder() *cacheRecorder {
+	return &cacheRecorder{
+		cache: c,
+		keys:  make(map[dskey.Key]struct{}),
+		seen:  make(map[int]bool),
+	}
+}
+
+// cacheRecorder implements the datastore.Getter interface for a restrictCache.
+type cacheRecorder struct {
+	cache *restrictCache
+	keys  map[dskey.Key]struct{}
+	seen  map[int]bool
+
+	// userSpecific is true, if one of the returned values depends on the user
+	// id.
+	userSpecific bool
+}
+
+// Get returns the restricted values for the keys.
+func (r *cacheRecorder) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
+	c := r.cache
+
+	var missing []dskey.Key
+	for _, key := range keys {
+		if _, ok := c.values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		restrictCtx := ctx
+		userSpecific := func() bool { return true }
+		if c.classer != nil {
+			restrictCtx, userSpecific = c.classer.TrackUserSpecific(ctx)
+		}
+
+		recorder := dsrecorder.New(c.getter)
+		data, err := c.restricter(recorder, c.uid).Get(restrictCtx, missing...)
+		if err != nil {
+			return nil, fmt.Errorf("restrict keys: %w", err)
+		}
+
+		c.deps = append(c.deps, recorder.Keys())
+		c.specific = append(c.specific, userSpecific())
+		for _, key := range missing {
+			c.values[key] = data[key]
+			c.batch[key] = len(c.deps) - 1
+		}
+	}
+
+	result := make(map[dskey.Key][]byte, len(keys))
+	for _, key := range keys {
+		result[key] = c.values[key]
+
+		batch := c.batch[key]
+		if r.seen[batch] {
+			continue
+		}
+		r.seen[batch] = true
+
+		if c.specific[batch] {
+			r.userSpecific = true
+		}
+
+		for dep := range c.deps[batch] {
+			r.keys[dep] = struct{}{}
+		}
+	}
+
+	return result, nil
+}
+
+// Keys returns all keys, that where needed to create the values.
+func (r *cacheRecorder) Keys() map[dskey.Key]struct{} {
+	return r.keys
+}
@@ -0,0 +1,83 @@
+package autoupdate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// resumeStore keeps the state of subscriptions from closed connections. A
+// client can use it to resume a subscription on a new connection without
+// receiving all data again.
+type resumeStore struct {
+	mu            sync.Mutex
+	subscriptions map[resumeKey]parkedSubscription
+}
+
+// resumeKey identifies the state of a subscription.
+//
+// Two subscriptions of the same user with the same request have the same state
+// at the same topic id. So it does not matter, which connection parked it.
+type resumeKey struct {
+	uid     int
+	request string
+	tid     uint64
+}
+
+// parkedSubscription is the state of a subscription after its connection was
+// closed.
+type parkedSubscription struct {
+	filter  filter
+	keys    []dskey.Key
+	created time.Time
+}
+
+func newResumeStore() *resumeStore {
+	return &resumeStore{
+		subscriptions: make(map[resumeKey]parkedSubscription),
+	}
+}
+
+// park saves the state of a subscription.
+func (s *resumeStore) park(key resumeKey, sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriptions[key] = parkedSubscription{
+		filter:  sub.filter.clone(),
+		keys:    sub.keys,
+		created: time.Now(),
+	}
+}
+
+// take restores the state of a parked subscription. Returns false, if there is
+// no state for the key.
+//
+// The state is not removed, so the client can resume more then once, for
+// example if the new connection fails before the first data is received.
+func (s *resumeStore) take(key resumeKey, sub *subscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parked, ok := s.subscriptions[key]
+	if !ok {
+		return false
+	}
+
+	sub.filter = parked.filter.clone()
+	sub.keys = parked.keys
+	return true
+}
+
+// prune removes all states that where parked before the given time.
+func (s *resumeStore) prune(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, parked := range s.subscriptions {
+		if parked.created.Before(before) {
+			delete(s.subscriptions, key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package autoupdate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// sharedStore shares the computed data between subscriptions of different
+// connections with the same request.
+//
+// Many clients, for example all delegates in a plenary session, subscribe to
+// the same request. Without the store, each connection would update its
+// keysbuilder and restrict the data on its own after each update.
+type sharedStore struct {
+	mu      sync.Mutex
+	results map[sharedKey]*sharedResult
+}
+
+// sharedKey identifies subscriptions, that get the same data.
+//
+// Users with the same permission class get the same data, as long as the
+// restricter does not compare the data with the user id. For example the own
+// user object or the own personal notes. Such results are only shared between
+// connections of the same user. So a key has either a class or a user id.
+type sharedKey struct {
+	class       string
+	uid         int
+	fingerprint string
+}
+
+// sharedResult is the computed data of a request at a topic id.
+type sharedResult struct {
+	tid     uint64
+	created time.Time
+
+	// ready gets closed, when the data was computed.
+	ready chan struct{}
+
+	// The fields are only valid after ready is closed.
+	keys     []dskey.Key
+	data     map[dskey.Key][]byte
+	hotkeys  map[dskey.Key]struct{}
+	position int
+	err      error
+
+	// uid is the user, that computed the data. If userSpecific is true, the
+	// data can only be used by this user.
+	uid          int
+	userSpecific bool
+}
+
+func newSharedStore() *sharedStore {
+	return &sharedStore{
+		results: make(map[sharedKey]*sharedResult),
+	}
+}
+
+// get returns the data for the key at the topic id tid or a later one.
+//
+// If no other connection has computed the data, it is created with the
+// function compute. If another connection computes the data at the moment,
+// get waits for it.
+//
+// The returned data must not be changed.
+func (s *sharedStore) get(ctx context.Context, key sharedKey, tid uint64, compute func() (*sharedResult, error)) (*sharedResult, error) {
+	s.mu.Lock()
+	result, ok := s.results[key]
+	if !ok || result.tid < tid {
+		result = &sharedResult{tid: tid, created: time.Now(), ready: make(chan struct{})}
+		s.results[key] = result
+		s.mu.Unlock()
+
+		computed, err := compute()
+		if err == nil {
+			result.keys = computed.keys
+			result.data = computed.data
+			result.hotkeys = computed.hotkeys
+			result.position = computed.position
+			result.uid = computed.uid
+			result.userSpecific = computed.userSpecific
+		}
+		result.err = err
+
+		if err != nil {
+			// The error can depend on the context of the connection. So other
+			// connections should not use it.
+			s.mu.Lock()
+			if s.results[key] == result {
+				delete(s.results, key)
+			}
+			s.mu.Unlock()
+		}
+
+		close(result.ready)
+		return result, err
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-result.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if result.err != nil {
+		// The other connection failed. Compute it without sharing.
+		return compute()
+	}
+
+	return result, nil
+}
+
+// prune removes all results, that are older then the given time.
+//
+// The results are only shared between connections, that get the same update
+// at the same time. So there is no need to keep them for long.
+func (s *sharedStore) prune(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, result := range s.results {
+		if result.created.Before(before) {
+			delete(s.results, key)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Formats, that can be used to encode the data.
+const (
+	formatJSON    = "json"
+	formatCBOR    = "cbor"
+	formatMsgpack = "msgpack"
+)
+
+// encoding defines, how the data is written to the client.
+//
+// JSON data without binary compression is written as one line per message.
+// All other encodings use binary frames. Each frame starts with its length as
+// four byte big endian unsigned integer.
+type encoding struct {
+	format string
+
+	// compress is true, if the data should be compressed with zstd.
+	compress bool
+
+	// binaryCompress is true, if the compressed data should not be base64
+	// encoded. In this case, all messages of a connection are compressed in one
+	// zstd stream.
+	binaryCompress bool
+
+	// dictionary is true, if the zstd stream should use the dictionary from
+	// zstdDict.
+	dictionary bool
+
+	// withPosition is true, if each message should contain the datastore
+	// position of the data. In this case, the data is wrapped in an object
+	// with the keys `data` and `position`.
+	withPosition bool
+}
+
+// encodingFromRequest returns the encoding, that the client requested.
+//
+// The format can be set with the query parameter `encoding` or the Accept
+// header. The compression is set with the query parameter `compress`. If its
+// value is `binary`, the compressed data is not base64 encoded. If its value
+// is `dict`, the zstd dictionary is also used. With the query parameter
+// `with_position`, each message contains the datastore position.
+func encodingFromRequest(r *http.Request) (encoding, error) {
+	compress := r.URL.Query().Get("compress")
+	enc := encoding{
+		format:         formatJSON,
+		compress:       r.URL.Query().Has("compress"),
+		binaryCompress: compress == "binary" || compress == "dict",
+		dictionary:     compress == "dict",
+		withPosition:   r.URL.Query().Has("with_position"),
+	}
+
+	if format := r.URL.Query().Get("encoding"); format != "" {
+		switch format {
+		case formatJSON, formatCBOR, formatMsgpack:
+			enc.format = format
+		default:
+			return encoding{}, invalidRequestError{fmt.Errorf("unknown encoding %s, use json, cbor or msgpack", format)}
+		}
+		return enc, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/cbor"):
+		enc.format = formatCBOR
+	case strings.Contains(accept, "application/msgpack"), strings.Contains(accept, "application/x-msgpack"):
+		enc.format = formatMsgpack
+	}
+
+	return enc, nil
+}
+
+// binary returns true, if the data is written in binary frames.
+func (e encoding) binary() bool {
+	return e.format != formatJSON || e.binaryCompress
+}
+
+// positionFrame is a json message with the datastore position of the data.
+type positionFrame struct {
+	Position int                        `json:"position"`
+	Data     map[string]json.RawMessage `json:"data"`
+}
+
+// jsonFrame returns the value of one message, that is encoded as json.
+func (e encoding) jsonFrame(data map[dskey.Key][]byte, position int) any {
+	if !e.withPosition {
+		return convertData(data)
+	}
+	return positionFrame{Position: position, Data: convertData(data)}
+}
+
+// dataWriter writes the messages of one connection.
+type dataWriter struct {
+	w   io.Writer
+	enc encoding
+
+	// zstdStream is used for binary compression. It writes to buf.
+	zstdStream *zstd.Encoder
+	buf        *bytes.Buffer
+
+	// lineEncoder compresses each json line. It is used for all lines of the
+	// connection.
+	lineEncoder *zstd.Encoder
+}
+
+func newDataWriter(w io.Writer, enc encoding) (*dataWriter, error) {
+	dw := dataWriter{
+		w:   w,
+		enc: enc,
+		buf: new(bytes.Buffer),
+	}
+
+	if enc.binary() && enc.compress {
+		stream, err := newZstdStream(dw.buf, enc.dictionary)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd stream: %w", err)
+		}
+		dw.zstdStream = stream
+	}
+
+	if !enc.binary() && enc.compress {
+		lineEncoder, err := newZstdLineEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd encoder: %w", err)
+		}
+		dw.lineEncoder = lineEncoder
+	}
+
+	return &dw, nil
+}
+
+// write writes one message. The position is only written, if the client
+// requested it.
+//
+// For binary frames with compression, the message is flushed, so the client
+// can decode it, but the zstd stream continues. So the following messages can
+// reference the data of the earlier messages.
+func (dw *dataWriter) write(data map[dskey.Key][]byte, position int) error {
+	if !dw.enc.binary() {
+		return writeJSONLine(dw.w, dw.enc.jsonFrame(data, position), dw.lineEncoder)
+	}
+
+	return dw.writeFrame(func(dst io.Writer) error {
+		if dw.enc.format == formatJSON {
+			return json.NewEncoder(dst).Encode(dw.enc.jsonFrame(data, position))
+		}
+		return encodeBinaryFrame(dst, dw.enc.format, data, position, dw.enc.withPosition)
+	})
+}
+
+// writeError writes an error message in the encoding of the data. So the
+// client can decode it like the other messages. The message is an object with
+// the key `error`.
+func (dw *dataWriter) writeError(msg errorMessage) error {
+	jsonValue := struct {
+		Error errorMessage `json:"error"`
+	}{msg}
+
+	if !dw.enc.binary() {
+		return writeJSONLine(dw.w, jsonValue, dw.lineEncoder)
+	}
+
+	return dw.writeFrame(func(dst io.Writer) error {
+		if dw.enc.format == formatJSON {
+			return json.NewEncoder(dst).Encode(jsonValue)
+		}
+
+		value := map[string]any{
+			"error": map[string]any{
+				"type":  msg.Type,
+				"msg":   msg.Msg,
+				"retry": msg.Retry,
+			},
+		}
+		return encodeBinaryValue(dst, dw.enc.format, value)
+	})
+}
+
+// writeFrame writes one binary frame. The function encode has to write the
+// content of the frame.
+func (dw *dataWriter) writeFrame(encode func(dst io.Writer) error) error {
+	dw.buf.Reset()
+	var dst io.Writer = dw.buf
+	if dw.zstdStream != nil {
+		dst = dw.zstdStream
+	}
+
+	if err := encode(dst); err != nil {
+		return fmt.Errorf("encode data: %w", err)
+	}
+
+	if dw.zstdStream != nil {
+		if err := dw.zstdStream.Flush(); err != nil {
+			return fmt.Errorf("compress data: %w", err)
+		}
+	}
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(dw.buf.Len()))
+	if _, err := dw.w.Write(append(length[:], dw.buf.Bytes()...)); err != nil {
+		return fmt.Errorf("write frame: %w", err)
+	}
+
+	return nil
+}
+
+// close releases the zstd encoders.
+func (dw *dataWriter) close() {
+	if dw.zstdStream != nil {
+		// The end of the stream is not sent to the client.
+		_ = dw.zstdStream.Close()
+	}
+
+	if dw.lineEncoder != nil {
+		_ = dw.lineEncoder.Close()
+	}
+}
+
+// writeJSONLine writes the value as json in one line. If the encoder is not
+// nil, the line is compressed as one zstd frame and base64 encoded.
+func writeJSONLine(w io.Writer, value any, encoder *zstd.Encoder) error {
+	if encoder == nil {
+		if err := json.NewEncoder(w).Encode(value); err != nil {
+			return fmt.Errorf("encode data: %w", err)
+		}
+		return nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode data: %w", err)
+	}
+
+	compressed := encoder.EncodeAll(append(encoded, '\n'), nil)
+	line := base64.RawStdEncoding.EncodeToString(compressed) + "\n"
+	if _, err := io.WriteString(w, line); err != nil {
+		return fmt.Errorf("write data: %w", err)
+	}
+	return nil
+}
+
+// cborEncMode encodes CBOR as defined in RFC 8949. The keys of maps are
+// sorted like in the core deterministic encoding.
+var cborEncMode = func() cbor.EncMode {
+	mode, err := cbor.EncOptions{Sort: cbor.SortCoreDeterministic}.EncMode()
+	if err != nil {
+		panic(fmt.Sprintf("invalid cbor options: %v", err))
+	}
+	return mode
+}()
+
+// encodeBinaryValue writes the value in the binary format.
+func encodeBinaryValue(w io.Writer, format string, value any) error {
+	switch format {
+	case formatCBOR:
+		return cborEncMode.NewEncoder(w).Encode(value)
+
+	case formatMsgpack:
+		enc := msgpack.NewEncoder(w)
+		enc.SetSortMapKeys(true)
+		enc.UseCompactInts(true)
+		return enc.Encode(value)
+
+	default:
+		return fmt.Errorf("unknown binary format %s", format)
+	}
+}
+
+// encodeBinaryFrame writes the data of one message. If withPosition is true,
+// the data is wrapped in a map with the keys `data` and `position`.
+func encodeBinaryFrame(w io.Writer, format string, data map[dskey.Key][]byte, position int, withPosition bool) error {
+	values, err := decodeData(data)
+	if err != nil {
+		return err
+	}
+
+	var value any = values
+	if withPosition {
+		value = map[string]any{"data": values, "position": position}
+	}
+
+	if err := encodeBinaryValue(w, format, value); err != nil {
+		return fmt.Errorf("encoding %s: %w", format, err)
+	}
+	return nil
+}
+
+// decodeData decodes the json values from the datastore, so they can be
+// encoded in a binary format. Numbers are decoded as int64, if possible, and
+// otherwise as float64.
+func decodeData(data map[dskey.Key][]byte) (map[string]any, error) {
+	values := make(map[string]any, len(data))
+	for k, v := range data {
+		if len(v) == 0 {
+			values[k.String()] = nil
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(v))
+		decoder.UseNumber()
+
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("decoding value of %s: %w", k, err)
+		}
+
+		value, err := convertNumbers(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding value of %s: %w", k, err)
+		}
+		values[k.String()] = value
+	}
+	return values, nil
+}
+
+// convertNumbers replaces all json.Number in a decoded json value with int64
+// or float64. Lists and maps are changed in place.
+func convertNumbers(value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return i, nil
+		}
+
+		f, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s: %w", v, err)
+		}
+		return f, nil
+
+	case []any:
+		for i := range v {
+			converted, err := convertNumbers(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+
+	case map[string]any:
+		for k := range v {
+			converted, err := convertNumbers(v[k])
+			if err != nil {
+				return nil, err
+			}
+			v[k] = converted
+		}
+	}
+	return value, nil
+}
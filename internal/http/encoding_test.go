@@ -0,0 +1,371 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestEncodeValue(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		json    string
+		cbor    string
+		msgpack string
+	}{
+		{"null", `null`, "f6", "c0"},
+		{"true", `true`, "f5", "c3"},
+		{"small int", `5`, "05", "05"},
+		{"int", `500`, "1901f4", "cd01f4"},
+		{"negative int", `-500`, "3901f3", "d1fe0c"},
+		{"float", `1.5`, "fb3ff8000000000000", "cb3ff8000000000000"},
+		{"string", `"foo"`, "63666f6f", "a3666f6f"},
+		{"list", `[1,2]`, "820102", "920102"},
+		{"object", `{"b":1,"a":true}`, "a26161f5616201", "82a161c3a16201"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[dskey.Key][]byte{dskey.MustKey("user/1/name"): []byte(tt.json)}
+			prefix := "a1" + "6b" + hex.EncodeToString([]byte("user/1/name"))
+
+			buf := new(bytes.Buffer)
+			if err := encodeBinaryFrame(buf, formatCBOR, data, 0, false); err != nil {
+				t.Fatalf("encode cbor: %v", err)
+			}
+
+			if got := hex.EncodeToString(buf.Bytes()); got != prefix+tt.cbor {
+				t.Errorf("got cbor %s, expected %s", got, prefix+tt.cbor)
+			}
+
+			prefix = "81" + "ab" + hex.EncodeToString([]byte("user/1/name"))
+			buf.Reset()
+			if err := encodeBinaryFrame(buf, formatMsgpack, data, 0, false); err != nil {
+				t.Fatalf("encode msgpack: %v", err)
+			}
+
+			if got := hex.EncodeToString(buf.Bytes()); got != prefix+tt.msgpack {
+				t.Errorf("got msgpack %s, expected %s", got, prefix+tt.msgpack)
+			}
+		})
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	data := map[dskey.Key][]byte{
+		dskey.MustKey("motion/1/title"):         []byte(`"my motion"`),
+		dskey.MustKey("motion/1/number_value"):  []byte(`-70000`),
+		dskey.MustKey("motion/1/weight"):        []byte(`2.5`),
+		dskey.MustKey("motion/1/submitter_ids"): []byte(`[1,2,300]`),
+		dskey.MustKey("motion/1/deleted"):       []byte(`false`),
+		dskey.MustKey("motion/1/text"):          []byte(`"` + strings.Repeat("long text ", 30) + `"`),
+		dskey.MustKey("motion/1/state_id"):      []byte(`null`),
+		dskey.MustKey("motion/1/extension"):     []byte(`{"a":[true,{"b":null}],"c":4294967296}`),
+		dskey.MustKey("motion/1/missing"):       nil,
+	}
+
+	expect := make(map[string]any, len(data))
+	for k, v := range data {
+		var value any
+		if v != nil {
+			if err := json.Unmarshal(v, &value); err != nil {
+				t.Fatalf("decoding %s: %v", k, err)
+			}
+		}
+		expect[k.String()] = value
+	}
+
+	for _, tt := range []struct {
+		format string
+		decode func([]byte) (any, error)
+	}{
+		{
+			formatCBOR,
+			func(encoded []byte) (any, error) {
+				var value any
+				decMode, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any{})}.DecMode()
+				if err != nil {
+					return nil, err
+				}
+				err = decMode.Unmarshal(encoded, &value)
+				return value, err
+			},
+		},
+		{
+			formatMsgpack,
+			func(encoded []byte) (any, error) {
+				var value any
+				err := msgpack.Unmarshal(encoded, &value)
+				return value, err
+			},
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := encodeBinaryFrame(buf, tt.format, data, 0, false); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+
+			decoded, err := tt.decode(buf.Bytes())
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			// Compare the values as json, so the types of the numbers do not
+			// matter.
+			gotJSON, err := json.Marshal(decoded)
+			if err != nil {
+				t.Fatalf("encoding decoded value as json: %v", err)
+			}
+
+			expectJSON, _ := json.Marshal(expect)
+			if string(gotJSON) != string(expectJSON) {
+				t.Errorf("got %s, expected %s", gotJSON, expectJSON)
+			}
+		})
+	}
+}
+
+func TestEncodingFromRequest(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		url    string
+		accept string
+		expect encoding
+	}{
+		{"default", "/", "", encoding{format: formatJSON}},
+		{"query", "/?encoding=msgpack", "", encoding{format: formatMsgpack}},
+		{"accept", "/", "application/cbor", encoding{format: formatCBOR}},
+		{"query before accept", "/?encoding=json", "application/cbor", encoding{format: formatJSON}},
+		{"compress", "/?compress", "", encoding{format: formatJSON, compress: true}},
+		{"binary compress", "/?compress=binary", "", encoding{format: formatJSON, compress: true, binaryCompress: true}},
+		{"with position", "/?with_position", "", encoding{format: formatJSON, withPosition: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			r.Header.Set("Accept", tt.accept)
+
+			got, err := encodingFromRequest(r)
+			if err != nil {
+				t.Fatalf("encodingFromRequest: %v", err)
+			}
+
+			if got != tt.expect {
+				t.Errorf("got %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestDataWriterPosition(t *testing.T) {
+	data := map[dskey.Key][]byte{dskey.MustKey("user/1/name"): []byte(`"foo"`)}
+
+	t.Run("json", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		dw, err := newDataWriter(buf, encoding{format: formatJSON, withPosition: true})
+		if err != nil {
+			t.Fatalf("newDataWriter: %v", err)
+		}
+		defer dw.close()
+
+		if err := dw.write(data, 42); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		expect := `{"position":42,"data":{"user/1/name":"foo"}}` + "\n"
+		if got := buf.String(); got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+	})
+
+	t.Run("cbor", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		dw, err := newDataWriter(buf, encoding{format: formatCBOR, withPosition: true})
+		if err != nil {
+			t.Fatalf("newDataWriter: %v", err)
+		}
+		defer dw.close()
+
+		if err := dw.write(data, 42); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		expect := "a2" +
+			"64" + hex.EncodeToString([]byte("data")) +
+			"a1" + "6b" + hex.EncodeToString([]byte("user/1/name")) + "63" + hex.EncodeToString([]byte("foo")) +
+			"68" + hex.EncodeToString([]byte("position")) + "182a"
+		if got := hex.EncodeToString(buf.Bytes()[4:]); got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+	})
+}
+
+func TestDataWriterError(t *testing.T) {
+	msg := errorMessage{Type: "too_slow", Msg: "slow", Retry: true}
+
+	t.Run("json", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		dw, err := newDataWriter(buf, encoding{format: formatJSON})
+		if err != nil {
+			t.Fatalf("newDataWriter: %v", err)
+		}
+		defer dw.close()
+
+		if err := dw.writeError(msg); err != nil {
+			t.Fatalf("writeError: %v", err)
+		}
+
+		expect := `{"error":{"type":"too_slow","msg":"slow","retry":true}}` + "\n"
+		if got := buf.String(); got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+	})
+
+	t.Run("cbor", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		dw, err := newDataWriter(buf, encoding{format: formatCBOR})
+		if err != nil {
+			t.Fatalf("newDataWriter: %v", err)
+		}
+		defer dw.close()
+
+		if err := dw.writeError(msg); err != nil {
+			t.Fatalf("writeError: %v", err)
+		}
+
+		expect := "a1" +
+			"65" + hex.EncodeToString([]byte("error")) +
+			"a3" +
+			"63" + hex.EncodeToString([]byte("msg")) + "64" + hex.EncodeToString([]byte("slow")) +
+			"64" + hex.EncodeToString([]byte("type")) + "68" + hex.EncodeToString([]byte("too_slow")) +
+			"65" + hex.EncodeToString([]byte("retry")) + "f5"
+		if got := hex.EncodeToString(buf.Bytes()[4:]); got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+	})
+}
+
+func TestDataWriterCompressedLines(t *testing.T) {
+	buf := new(bytes.Buffer)
+	dw, err := newDataWriter(buf, encoding{format: formatJSON, compress: true})
+	if err != nil {
+		t.Fatalf("newDataWriter: %v", err)
+	}
+	defer dw.close()
+
+	messages := []map[dskey.Key][]byte{
+		{dskey.MustKey("motion/1/title"): []byte(`"my motion"`)},
+		{dskey.MustKey("motion/1/title"): []byte(`"other motion"`)},
+	}
+
+	for _, msg := range messages {
+		if err := dw.write(msg, 0); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatalf("creating decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	// Each line has to be decodable on its own.
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expect := []string{`{"motion/1/title":"my motion"}`, `{"motion/1/title":"other motion"}`}
+	if len(lines) != len(expect) {
+		t.Fatalf("got %d lines, expected %d", len(lines), len(expect))
+	}
+
+	for i, line := range lines {
+		compressed, err := base64.RawStdEncoding.DecodeString(line)
+		if err != nil {
+			t.Fatalf("line %d: decoding base64: %v", i, err)
+		}
+
+		decoded, err := decoder.DecodeAll(compressed, nil)
+		if err != nil {
+			t.Fatalf("line %d: decoding zstd: %v", i, err)
+		}
+
+		if got := string(decoded); got != expect[i]+"\n" {
+			t.Errorf("line %d: got %q, expected %q", i, got, expect[i]+"\n")
+		}
+	}
+}
+
+func TestDataWriterStream(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		enc     encoding
+		options []zstd.DOption
+	}{
+		{"binary", encoding{format: formatJSON, compress: true, binaryCompress: true}, nil},
+		{"dictionary", encoding{format: formatJSON, compress: true, binaryCompress: true, dictionary: true}, []zstd.DOption{zstd.WithDecoderDicts(zstdDict)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			dw, err := newDataWriter(buf, tt.enc)
+			if err != nil {
+				t.Fatalf("newDataWriter: %v", err)
+			}
+			defer dw.close()
+
+			messages := []map[dskey.Key][]byte{
+				{dskey.MustKey("motion/1/title"): []byte(`"my motion"`), dskey.MustKey("motion/1/text"): []byte(`"my text"`)},
+				{dskey.MustKey("motion/1/title"): []byte(`"my motion"`)},
+			}
+
+			var frameSizes []int
+			for _, msg := range messages {
+				before := buf.Len()
+				if err := dw.write(msg, 0); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				frameSizes = append(frameSizes, buf.Len()-before)
+			}
+
+			// Remove the frame headers and decode the stream.
+			var stream []byte
+			rest := buf.Bytes()
+			for len(rest) > 0 {
+				length := int(binary.BigEndian.Uint32(rest[:4]))
+				stream = append(stream, rest[4:4+length]...)
+				rest = rest[4+length:]
+			}
+
+			decoder, err := zstd.NewReader(bytes.NewReader(stream), tt.options...)
+			if err != nil {
+				t.Fatalf("creating decoder: %v", err)
+			}
+			defer decoder.Close()
+
+			decoded, err := io.ReadAll(decoder)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("decoding stream: %v", err)
+			}
+
+			expect := `{"motion/1/text":"my text","motion/1/title":"my motion"}` + "\n" + `{"motion/1/title":"my motion"}` + "\n"
+			if string(decoded) != expect {
+				t.Errorf("got %q, expected %q", decoded, expect)
+			}
+
+			// The second message is part of the first one. So it has to be
+			// smaller then its raw content.
+			if frameSizes[1] >= len(`{"motion/1/title":"my motion"}`) {
+				t.Errorf("second frame has %d bytes, expected it to be compressed", frameSizes[1])
+			}
+		})
+	}
+}
@@ -1,6 +1,46 @@
 package http
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+)
+
+const internalErrorMsg = "Something went wrong on the server. The admin is already informed."
+
+// errorMessage is an error, that is sent to the client.
+//
+// Type is a stable code of the error. Retry is true, if the client can send
+// the same request again, for example after a reconnect. Otherwise it has to
+// change the request or stop.
+type errorMessage struct {
+	Type  string `json:"type"`
+	Msg   string `json:"msg"`
+	Retry bool   `json:"retry"`
+}
+
+// newErrorMessage returns the message for an error. Errors, that are not
+// client errors, are logged.
+//
+// Client errors can only be retried, if they implement the Retryer interface.
+// Internal errors can always be retried.
+func newErrorMessage(err error) errorMessage {
+	var errClient ClientError
+	if !errors.As(err, &errClient) {
+		oserror.Handle(err)
+		return errorMessage{Type: "internal_error", Msg: internalErrorMsg, Retry: true}
+	}
+
+	msg := errorMessage{Type: errClient.Type(), Msg: errClient.Error()}
+
+	var retryer Retryer
+	if errors.As(err, &retryer) {
+		msg.Retry = retryer.Retry()
+	}
+	return msg
+}
 
 type invalidRequestError struct {
 	err error
@@ -13,3 +53,43 @@ func (e invalidRequestError) Error() string {
 func (e invalidRequestError) Type() string {
 	return "invalid_request"
 }
+
+// positionTimeoutError is returned, when the service did not receive the
+// position, that a client waits for, in time.
+type positionTimeoutError struct {
+	position int
+}
+
+func (e positionTimeoutError) Error() string {
+	return fmt.Sprintf("the position %d was not reached in time", e.position)
+}
+
+func (e positionTimeoutError) Type() string {
+	return "position_timeout"
+}
+
+func (e positionTimeoutError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e positionTimeoutError) Retry() bool {
+	return true
+}
+
+// positionUnknownError is returned, when a client waits for a position, but
+// the datastore does not know the positions of its updates.
+type positionUnknownError struct {
+	position int
+}
+
+func (e positionUnknownError) Error() string {
+	return fmt.Sprintf("the position %d can not be reached, since the datastore does not know the positions of its updates", e.position)
+}
+
+func (e positionUnknownError) Type() string {
+	return "position_unknown"
+}
+
+func (e positionUnknownError) StatusCode() int {
+	return http.StatusNotImplemented
+}
@@ -0,0 +1,125 @@
+// This tool trains the zstd dictionary in the file zstd_dictionary.
+//
+// It needs the zstd command line tool. To call it, just call "go generate
+// ./..." in the root folder of the repository.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict"
+)
+
+const (
+	samplesPerCollection = 30
+	maxDictSize          = 64 << 10
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("Usage: %s OUTPUT_FILE", os.Args[0])
+	}
+
+	dir, err := os.MkdirTemp("", "zstd-samples")
+	if err != nil {
+		log.Fatalf("Can not create sample dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, checksum, err := writeSamples(dir)
+	if err != nil {
+		log.Fatalf("Can not write samples: %v", err)
+	}
+
+	// The id has to be outside of the reserved range from 0 to 32767. It is
+	// created from the samples, so the dictionary only changes, when the
+	// models change.
+	dictID := checksum | 1<<31
+
+	args := []string{"--train", "-q", fmt.Sprintf("--maxdict=%d", maxDictSize), fmt.Sprintf("--dictID=%d", dictID), "-o", os.Args[1]}
+	cmd := exec.Command("zstd", append(args, files...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Can not train dictionary: %v", err)
+	}
+}
+
+// writeSamples writes messages like the autoupdate service sends them. Each
+// message contains some fields of some objects of one collection.
+//
+// It returns the filenames and a checksum of the samples.
+func writeSamples(dir string) ([]string, uint32, error) {
+	random := rand.New(rand.NewSource(1))
+	checksum := crc32.NewIEEE()
+
+	collections := restrict.Collections()
+	sort.Strings(collections)
+
+	var files []string
+	for _, collection := range collections {
+		fields := restrict.FieldsForCollection(collection)
+		sort.Strings(fields)
+
+		for i := 0; i < samplesPerCollection; i++ {
+			sample := new(bytes.Buffer)
+			sample.WriteString("{")
+			objects := 1 + random.Intn(3)
+			for o := 0; o < objects; o++ {
+				id := 1 + random.Intn(200)
+				for _, field := range fields {
+					if strings.Contains(field, "$") || random.Intn(3) == 0 {
+						continue
+					}
+
+					if sample.Len() > 1 {
+						sample.WriteString(",")
+					}
+					fmt.Fprintf(sample, `"%s/%d/%s":%s`, collection, id, field, sampleValue(random, field))
+				}
+			}
+			sample.WriteString("}\n")
+
+			name := filepath.Join(dir, fmt.Sprintf("%s-%d.json", collection, i))
+			if err := os.WriteFile(name, sample.Bytes(), 0o600); err != nil {
+				return nil, 0, fmt.Errorf("writing sample %s: %w", name, err)
+			}
+			checksum.Write(sample.Bytes())
+			files = append(files, name)
+		}
+	}
+	return files, checksum.Sum32(), nil
+}
+
+// sampleValue returns a json value, that looks like a value of the field.
+func sampleValue(random *rand.Rand, field string) string {
+	switch {
+	case strings.HasSuffix(field, "_ids"):
+		ids := make([]string, random.Intn(5))
+		for i := range ids {
+			ids[i] = fmt.Sprint(1 + random.Intn(200))
+		}
+		return "[" + strings.Join(ids, ",") + "]"
+
+	case strings.HasSuffix(field, "_id"):
+		return fmt.Sprint(1 + random.Intn(200))
+
+	case field == "id" || field == "weight" || strings.HasSuffix(field, "_timestamp"):
+		return fmt.Sprint(random.Intn(100000))
+
+	case strings.HasPrefix(field, "is_") || strings.HasPrefix(field, "enable_") || strings.HasPrefix(field, "allow_"):
+		return fmt.Sprint(random.Intn(2) == 0)
+
+	default:
+		return fmt.Sprintf(`"%s %d"`, field, random.Intn(100))
+	}
+}
@@ -4,7 +4,6 @@ package http
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,13 +13,14 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/metric"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
-	"github.com/klauspost/compress/zstd"
 )
 
 const (
@@ -28,15 +28,81 @@ const (
 	prefixInternal = "/internal/autoupdate"
 )
 
+const defaultPositionTimeout = 10 * time.Second
+
+// Option is an optional argument for Run and the handlers.
+type Option func(*config)
+
+type config struct {
+	heartbeat       time.Duration
+	positionTimeout time.Duration
+	presets         *keysbuilder.Presets
+	keysLimits      keysbuilder.Limits
+	allowedOrigins  []string
+}
+
+func newConfig(options []Option) config {
+	cfg := config{
+		positionTimeout: defaultPositionTimeout,
+	}
+	for _, o := range options {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// WithHeartbeat sets the interval for heartbeats. If no data was sent to a
+// client for this time, an empty message is sent. This keeps proxies from
+// closing idle connections. Zero disables the heartbeat.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(c *config) {
+		c.heartbeat = interval
+	}
+}
+
+// WithPositionTimeout sets the time, a request with the query parameter
+// `min_position` waits for the position.
+func WithPositionTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.positionTimeout = timeout
+	}
+}
+
+// WithPresets sets the presets, that can be used with the query parameter
+// `preset`.
+func WithPresets(presets *keysbuilder.Presets) Option {
+	return func(c *config) {
+		c.presets = presets
+	}
+}
+
+// WithKeysLimits sets the limits for the complexity of keys requests.
+func WithKeysLimits(limits keysbuilder.Limits) Option {
+	return func(c *config) {
+		c.keysLimits = limits
+	}
+}
+
+// WithAllowedOrigins sets the origins of other websites, that can open a
+// websocket. Connections from the host of the service are always allowed.
+func WithAllowedOrigins(origins []string) Option {
+	return func(c *config) {
+		c.allowedOrigins = origins
+	}
+}
+
 // Run starts the http server.
-func Run(ctx context.Context, addr string, auth Authenticater, autoupdate *autoupdate.Autoupdate) error {
+func Run(ctx context.Context, addr string, auth Authenticater, autoupdate *autoupdate.Autoupdate, options ...Option) error {
 	requestCount := metric.NewCurrentCounter("connection")
 	metric.Register(requestCount.Metric)
 
 	mux := http.NewServeMux()
 	HandleHealth(mux)
-	HandleAutoupdate(mux, auth, autoupdate, requestCount)
+	HandleZstdDictionary(mux)
+	HandleAutoupdate(mux, auth, autoupdate, requestCount, options...)
+	HandleWebsocket(mux, auth, autoupdate, requestCount, options...)
 	HandleHistoryInformation(mux, auth, autoupdate)
+	HandleDiff(mux, auth, autoupdate, options...)
 	HandleRestrictFQIDs(mux, autoupdate)
 
 	srv := &http.Server{
@@ -67,13 +133,19 @@ func Run(ctx context.Context, addr string, auth Authenticater, autoupdate *autou
 
 // Connecter returns an connect object.
 type Connecter interface {
+	MultiplexConnecter
+	TimePositioner
 	Connect(userID int, kb autoupdate.KeysBuilder) autoupdate.DataProvider
 	SingleData(ctx context.Context, userID int, kb autoupdate.KeysBuilder, position int) (map[dskey.Key][]byte, error)
+	Position() int
+	WaitForPosition(ctx context.Context, position int) error
 }
 
 // HandleAutoupdate builds the requested keys from the body of a request. The
 // body has to be in the format specified in the keysbuilder package.
-func HandleAutoupdate(mux *http.ServeMux, auth Authenticater, connecter Connecter, counter *metric.CurrentCounter) {
+func HandleAutoupdate(mux *http.ServeMux, auth Authenticater, connecter Connecter, counter *metric.CurrentCounter, options ...Option) {
+	cfg := newConfig(options)
+
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Cache-Control", "no-store, max-age=0")
@@ -107,41 +179,95 @@ func HandleAutoupdate(mux *http.ServeMux, auth Authenticater, connecter Connecte
 			return
 		}
 
-		builder := keysbuilder.FromBuilders(queryBuilder, bodyBuilder)
-
-		rawPosition := r.URL.Query().Get("position")
-		position := 0
-		if rawPosition != "" {
-			p, err := strconv.Atoi(rawPosition)
+		builders := []*keysbuilder.Builder{queryBuilder, bodyBuilder}
+		if name := r.URL.Query().Get("preset"); name != "" {
+			presetBuilder, err := presetFromRequest(r, name, cfg.presets)
 			if err != nil {
-				handleErrorWithStatus(w, invalidRequestError{fmt.Errorf("position has to be a number, not %s", rawPosition)})
+				handleErrorWithStatus(w, fmt.Errorf("building keysbuilder from preset: %w", err))
+				return
+			}
+			builders = append(builders, presetBuilder)
+		}
+
+		builder := keysbuilder.FromBuilders(builders...)
+		builder.SetLimits(cfg.keysLimits)
+
+		position, err := positionFromRequest(r, connecter)
+		if err != nil {
+			handleErrorWithStatus(w, err)
+			return
+		}
+
+		minPosition, err := intFromQuery(r, "min_position")
+		if err != nil {
+			handleErrorWithStatus(w, err)
+			return
+		}
+
+		if minPosition > 0 {
+			if err := waitForPosition(ctx, connecter, minPosition, cfg.positionTimeout); err != nil {
+				handleErrorWithStatus(w, err)
 				return
 			}
-			position = p
 		}
 
 		if r.URL.Query().Has("profile_restrict") {
 			ctx = oserror.ContextWithTag(ctx, "profile_restrict")
 		}
 
-		var compress bool
-		if r.URL.Query().Has("compress") {
-			compress = true
+		enc, err := encodingFromRequest(r)
+		if err != nil {
+			handleErrorWithStatus(w, err)
+			return
 		}
 
 		if r.URL.Query().Has("single") || position != 0 {
+			dataPosition := position
+			if dataPosition == 0 {
+				// The data is read after the position, so it is at least at
+				// this position.
+				dataPosition = connecter.Position()
+			}
+
 			data, err := connecter.SingleData(ctx, uid, builder, position)
 			if err != nil {
 				handleErrorWithStatus(w, fmt.Errorf("getting single data: %w", err))
 				return
 			}
 
-			if err := writeData(w, data, compress); err != nil {
+			dw, err := newDataWriter(w, enc)
+			if err != nil {
+				handleErrorWithStatus(w, err)
+				return
+			}
+			defer dw.close()
+
+			if err := dw.write(data, dataPosition); err != nil {
 				handleErrorWithoutStatus(w, err)
 			}
 			return
 		}
 
+		if r.URL.Query().Get("format") == "sse" {
+			if enc.binary() {
+				handleErrorWithStatus(w, invalidRequestError{fmt.Errorf("server-sent events can only be used with json and without binary compression")})
+				return
+			}
+
+			since, err := sseSince(r)
+			if err != nil {
+				handleErrorWithStatus(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			request := "k=" + r.URL.Query().Get("k") + "\n" + compactedBody.String()
+			if err := sendSSE(ctx, w, uid, builder, request, since, connecter, enc, cfg.heartbeat); err != nil {
+				handleErrorWithoutStatus(&sseWriter{ResponseWriter: w, event: "error"}, err)
+			}
+			return
+		}
+
 		var wr io.Writer = w
 		if r.URL.Query().Has("skip_first") {
 			// TODO: This will not compress the first data. For the performance
@@ -149,7 +275,7 @@ func HandleAutoupdate(mux *http.ServeMux, auth Authenticater, connecter Connecte
 			wr = newSkipFirst(w)
 		}
 
-		if err := sendMessages(ctx, wr, uid, builder, connecter, compress); err != nil {
+		if err := sendMessages(ctx, wr, uid, builder, connecter, enc, cfg.heartbeat); err != nil {
 			handleErrorWithoutStatus(w, err)
 			return
 		}
@@ -169,51 +295,55 @@ func HandleAutoupdate(mux *http.ServeMux, auth Authenticater, connecter Connecte
 	)
 }
 
-func writeData(w io.Writer, data map[dskey.Key][]byte, compress bool) error {
-	converted := make(map[string]json.RawMessage, len(data))
-	for k, v := range data {
-		converted[k.String()] = v
-	}
-
-	if compress {
-		defer fmt.Fprintln(w)
-		base64Encoder := base64.NewEncoder(base64.RawStdEncoding, w)
-		defer base64Encoder.Close()
-
-		zstdEncoder, err := zstd.NewWriter(base64Encoder)
-		if err != nil {
-			return fmt.Errorf("create encoder: %w", err)
-		}
-		defer zstdEncoder.Close()
-		w = zstdEncoder
-	}
-
-	if err := json.NewEncoder(w).Encode(converted); err != nil {
-		return fmt.Errorf("encode data: %w", err)
-	}
-
-	return nil
-}
-
 // HistoryInformationer is an object, that can write the history information for
 // an object.
 type HistoryInformationer interface {
-	HistoryInformation(ctx context.Context, uid int, fqid string, w io.Writer) error
+	TimePositioner
+	HistoryInformation(ctx context.Context, uid int, fqid string, filter autoupdate.HistoryFilter, w io.Writer) error
+	HistoryInformationBatch(ctx context.Context, uid int, fqids []string, filter autoupdate.HistoryFilter, w io.Writer) error
+}
+
+// TimePositioner returns the datastore position at a time.
+type TimePositioner interface {
+	PositionAt(ctx context.Context, t time.Time) (int, error)
 }
 
 // HandleHistoryInformation registers the route to return the history information info
 // for an fqid.
+//
+// With the query parameter `fqids`, the history information of many fqids is
+// returned. Fqids that the user can not see are skipped.
 func HandleHistoryInformation(mux *http.ServeMux, auth Authenticater, hi HistoryInformationer) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		uid := auth.FromContext(r.Context())
 
 		fqid := r.URL.Query().Get("fqid")
-		if fqid == "" {
+		rawFQIDs := r.URL.Query().Get("fqids")
+		if fqid == "" && rawFQIDs == "" {
 			handleErrorWithStatus(w, invalidRequestError{fmt.Errorf("History Information needs an fqid")})
 			return
 		}
 
-		if err := hi.HistoryInformation(r.Context(), uid, fqid, w); err != nil {
+		filter, err := historyFilterFromRequest(r, hi)
+		if err != nil {
+			handleErrorWithStatus(w, err)
+			return
+		}
+
+		if rawFQIDs != "" {
+			fqids := strings.Split(rawFQIDs, ",")
+			if fqid != "" {
+				fqids = append(fqids, fqid)
+			}
+
+			if err := hi.HistoryInformationBatch(r.Context(), uid, fqids, filter, w); err != nil {
+				handleErrorWithStatus(w, fmt.Errorf("getting history information: %w", err))
+				return
+			}
+			return
+		}
+
+		if err := hi.HistoryInformation(r.Context(), uid, fqid, filter, w); err != nil {
 			handleErrorWithStatus(w, fmt.Errorf("getting history information: %w", err))
 			return
 		}
@@ -222,18 +352,128 @@ func HandleHistoryInformation(mux *http.ServeMux, auth Authenticater, hi History
 	mux.Handle(prefixPublic+"/history_information", authMiddleware(handler, auth))
 }
 
-func sendMessages(ctx context.Context, w io.Writer, uid int, kb autoupdate.KeysBuilder, connecter Connecter, compress bool) error {
-	next := connecter.Connect(uid, kb)
+// Differ returns the difference of the data between two positions.
+type Differ interface {
+	Diff(ctx context.Context, userID int, kb autoupdate.KeysBuilder, from, to int) (autoupdate.DataDiff, error)
+}
+
+// HandleDiff registers the route to return the difference of the data of a
+// keys request between the positions in the query parameters `from` and `to`.
+//
+// The keys request is given like on the autoupdate route.
+func HandleDiff(mux *http.ServeMux, auth Authenticater, differ Differ, options ...Option) {
+	cfg := newConfig(options)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		defer r.Body.Close()
+		uid := auth.FromContext(r.Context())
+
+		from, err := intFromQuery(r, "from")
+		if err != nil {
+			handleErrorWithStatus(w, err)
+			return
+		}
+
+		to, err := intFromQuery(r, "to")
+		if err != nil {
+			handleErrorWithStatus(w, err)
+			return
+		}
+
+		if from <= 0 || to <= 0 {
+			handleErrorWithStatus(w, invalidRequestError{fmt.Errorf("diff needs the positions from and to")})
+			return
+		}
+
+		queryBuilder, err := keysbuilder.FromKeys(strings.Split(r.URL.Query().Get("k"), ",")...)
+		if err != nil {
+			handleErrorWithStatus(w, fmt.Errorf("building keysbuilder from query: %w", err))
+			return
+		}
+
+		bodyBuilder, err := keysbuilder.ManyFromJSON(r.Body)
+		if err != nil {
+			handleErrorWithStatus(w, fmt.Errorf("building keysbuilder from body: %w", err))
+			return
+		}
+
+		builder := keysbuilder.FromBuilders(queryBuilder, bodyBuilder)
+		builder.SetLimits(cfg.keysLimits)
+
+		diff, err := differ.Diff(r.Context(), uid, builder, from, to)
+		if err != nil {
+			handleErrorWithStatus(w, fmt.Errorf("getting diff: %w", err))
+			return
+		}
+
+		changed := make(map[string]diffValue, len(diff.Changed))
+		for k, v := range diff.Changed {
+			changed[k.String()] = diffValue{From: v.From, To: v.To}
+		}
+
+		out := struct {
+			Added   map[string]json.RawMessage `json:"added"`
+			Removed map[string]json.RawMessage `json:"removed"`
+			Changed map[string]diffValue       `json:"changed"`
+		}{
+			Added:   convertData(diff.Added),
+			Removed: convertData(diff.Removed),
+			Changed: changed,
+		}
+
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			handleErrorWithoutStatus(w, fmt.Errorf("encoding diff: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(prefixPublic+"/diff", authMiddleware(handler, auth))
+}
+
+// diffValue is the json representation of autoupdate.ValueChange.
+type diffValue struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+func sendMessages(ctx context.Context, w io.Writer, uid int, kb autoupdate.KeysBuilder, connecter Connecter, enc encoding, heartbeat time.Duration) error {
+	dw, err := newDataWriter(w, enc)
+	if err != nil {
+		return fmt.Errorf("create data writer: %w", err)
+	}
+	defer dw.close()
+
+	var position int
+	sendHeartbeat := func() error {
+		if err := dw.write(nil, position); err != nil {
+			return fmt.Errorf("write heartbeat: %w", err)
+		}
+		w.(http.Flusher).Flush()
+		return nil
+	}
+
+	next := connectFrames(uid, kb, connecter, enc.withPosition)
 
 	for f, ok := next(); ok; f, ok = next() {
 		// This blocks, until there is new data. It also unblocks, when the
 		// client context is done.
-		data, err := f(ctx)
+		data, err := waitWithHeartbeat(ctx, heartbeat, f, sendHeartbeat)
 		if err != nil {
-			return fmt.Errorf("getting next message: %w", err)
+			if clientClosed(err) {
+				return err
+			}
+
+			// The error is sent in the encoding of the stream, so the client
+			// can decode it.
+			if err := dw.writeError(newErrorMessage(fmt.Errorf("getting next message: %w", err))); err != nil {
+				return fmt.Errorf("write error: %w", err)
+			}
+			return nil
 		}
 
-		if err := writeData(w, data, compress); err != nil {
+		position = data.Position
+		if err := dw.write(data.Subscriptions[""], position); err != nil {
 			return fmt.Errorf("write data: %w", err)
 		}
 		w.(http.Flusher).Flush()
@@ -241,6 +481,243 @@ func sendMessages(ctx context.Context, w io.Writer, uid int, kb autoupdate.KeysB
 	return ctx.Err()
 }
 
+// presetFromRequest returns the keysbuilder for a preset. The parameters of
+// the preset are read from the query.
+func presetFromRequest(r *http.Request, name string, presets *keysbuilder.Presets) (*keysbuilder.Builder, error) {
+	if presets == nil {
+		return nil, invalidRequestError{fmt.Errorf("unknown preset %s", name)}
+	}
+
+	params := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		params[key] = values[0]
+	}
+
+	return presets.Builder(name, params)
+}
+
+// intFromQuery returns the number from the query parameter with the given
+// name. Returns 0, if the parameter is not set.
+func intFromQuery(r *http.Request, name string) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	position, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, invalidRequestError{fmt.Errorf("%s has to be a number, not %s", name, raw)}
+	}
+	return position, nil
+}
+
+// historyFilterFromRequest returns the filter for the history information
+// from the query parameters.
+func historyFilterFromRequest(r *http.Request, positioner TimePositioner) (autoupdate.HistoryFilter, error) {
+	var filter autoupdate.HistoryFilter
+
+	position, err := positionFromRequest(r, positioner)
+	if err != nil {
+		return filter, err
+	}
+	filter.MaxPosition = position
+
+	// The cursor of a page is the value for before_position of the next
+	// page.
+	beforeName := "before_position"
+	if r.URL.Query().Has("cursor") {
+		beforeName = "cursor"
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{beforeName, &filter.BeforePosition},
+		{"user_id", &filter.UserID},
+		{"limit", &filter.Limit},
+	} {
+		value, err := intFromQuery(r, param.name)
+		if err != nil {
+			return filter, err
+		}
+
+		if value < 0 {
+			return filter, invalidRequestError{fmt.Errorf("%s can not be negative", param.name)}
+		}
+		*param.value = value
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from_time", &filter.From},
+		{"to_time", &filter.To},
+	} {
+		raw := r.URL.Query().Get(param.name)
+		if raw == "" {
+			continue
+		}
+
+		timestamp, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return filter, invalidRequestError{fmt.Errorf("%s has to be a unix timestamp, not %s", param.name, raw)}
+		}
+		*param.value = time.Unix(timestamp, 0)
+	}
+
+	return filter, nil
+}
+
+// positionFromRequest returns the position from the query parameter
+// `position`. Instead of the position, the query parameter `at` can be used
+// with a unix timestamp. In this case, the last position before this time is
+// returned.
+//
+// Returns 0, if both parameters are not set.
+func positionFromRequest(r *http.Request, positioner TimePositioner) (int, error) {
+	position, err := intFromQuery(r, "position")
+	if err != nil {
+		return 0, err
+	}
+
+	rawAt := r.URL.Query().Get("at")
+	if rawAt == "" {
+		return position, nil
+	}
+
+	if position != 0 {
+		return 0, invalidRequestError{fmt.Errorf("position and at can not be used together")}
+	}
+
+	at, err := strconv.ParseInt(rawAt, 10, 64)
+	if err != nil {
+		return 0, invalidRequestError{fmt.Errorf("at has to be a unix timestamp, not %s", rawAt)}
+	}
+
+	position, err = positioner.PositionAt(r.Context(), time.Unix(at, 0))
+	if err != nil {
+		return 0, fmt.Errorf("getting position at %d: %w", at, err)
+	}
+
+	if position == 0 {
+		return 0, invalidRequestError{fmt.Errorf("there is no data at %d", at)}
+	}
+	return position, nil
+}
+
+// waitForPosition waits until the connecter has reached the position. Returns
+// a positionTimeoutError, if this takes longer then the timeout, and a
+// positionUnknownError, if the position can not be reached.
+func waitForPosition(ctx context.Context, connecter Connecter, position int, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := connecter.WaitForPosition(waitCtx, position); err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return positionTimeoutError{position: position}
+		}
+
+		if errors.Is(err, datastore.ErrNoPosition) {
+			return positionUnknownError{position: position}
+		}
+		return fmt.Errorf("waiting for position: %w", err)
+	}
+	return nil
+}
+
+// connectFrames connects to the autoupdate service and returns the data in
+// the format of a multiplexer with the subscription id "".
+//
+// Only the multiplexer knows the position of the data. So it is only used,
+// if the client requested the position.
+func connectFrames(uid int, kb autoupdate.KeysBuilder, connecter Connecter, withPosition bool) func() (func(context.Context) (autoupdate.MultiplexData, error), bool) {
+	if withPosition {
+		multiplexer := connecter.Multiplex(uid)
+		multiplexer.Subscribe("", kb)
+		return func() (func(context.Context) (autoupdate.MultiplexData, error), bool) {
+			f, ok := multiplexer.Next()
+			if !ok {
+				return nil, false
+			}
+
+			return func(ctx context.Context) (autoupdate.MultiplexData, error) {
+				data, err := f(ctx)
+				if err != nil {
+					return autoupdate.MultiplexData{}, err
+				}
+
+				if err := data.Errors[""]; err != nil {
+					return autoupdate.MultiplexData{}, err
+				}
+				return data, nil
+			}, true
+		}
+	}
+
+	next := connecter.Connect(uid, kb)
+	return func() (func(context.Context) (autoupdate.MultiplexData, error), bool) {
+		f, ok := next()
+		if !ok {
+			return nil, false
+		}
+
+		return func(ctx context.Context) (autoupdate.MultiplexData, error) {
+			data, err := f(ctx)
+			if err != nil {
+				return autoupdate.MultiplexData{}, err
+			}
+			return autoupdate.MultiplexData{Subscriptions: map[string]map[dskey.Key][]byte{"": data}}, nil
+		}, true
+	}
+}
+
+// waitWithHeartbeat calls f and returns its result. While f blocks, heartbeat
+// is called each time, the interval has passed.
+//
+// If the interval is zero, f is called directly.
+func waitWithHeartbeat[T any](ctx context.Context, interval time.Duration, f func(context.Context) (T, error), heartbeat func() error) (T, error) {
+	if interval <= 0 {
+		return f(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := f(ctx)
+		done <- result{value, err}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r := <-done:
+			return r.value, r.err
+
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				// Wait for f, so it is not running, when the caller calls it
+				// again.
+				cancel()
+				<-done
+
+				var zero T
+				return zero, err
+			}
+		}
+	}
+}
+
 type restrictFQIDser interface {
 	RestrictFQIDs(ctx context.Context, uid int, fqids []string) (map[string]map[string][]byte, error)
 }
@@ -337,8 +814,7 @@ func handleError(w http.ResponseWriter, err error, writeStatusCode bool, interna
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
 
-	if oserror.ContextDone(err) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
-		// Client closed connection.
+	if clientClosed(err) {
 		return
 	}
 
@@ -354,7 +830,7 @@ func handleError(w http.ResponseWriter, err error, writeStatusCode bool, interna
 			w.WriteHeader(status)
 		}
 
-		fmt.Fprintf(w, `{"error": {"type": "%s", "msg": "%s"}}`, errClient.Type(), quote(errClient.Error()))
+		writeErrorMessage(w, newErrorMessage(err))
 		return
 	}
 
@@ -362,13 +838,31 @@ func handleError(w http.ResponseWriter, err error, writeStatusCode bool, interna
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 
-	clientOutput := `{"error": {"type": "InternalError", "msg": "Something went wrong on the server. The admin is already informed."}}`
 	if internal {
-		clientOutput = err.Error()
+		oserror.Handle(err)
+		fmt.Fprintln(w, err.Error())
+		return
 	}
 
-	oserror.Handle(err)
-	fmt.Fprintln(w, clientOutput)
+	// The message has to be written with one call to Write. Otherwise, an
+	// sseWriter sends it as two events.
+	buf := new(bytes.Buffer)
+	writeErrorMessage(buf, newErrorMessage(err))
+	buf.WriteString("\n")
+
+	// If the connection is broken, there is nothing to do about it.
+	_, _ = w.Write(buf.Bytes())
+}
+
+// clientClosed returns true, if the error happened, because the client closed
+// the connection.
+func clientClosed(err error) bool {
+	return oserror.ContextDone(err) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// writeErrorMessage writes the error message as json.
+func writeErrorMessage(w io.Writer, msg errorMessage) {
+	fmt.Fprintf(w, `{"error": {"type": "%s", "msg": "%s", "retry": %t}}`, msg.Type, quote(msg.Msg), msg.Retry)
 }
 
 // quote decodes changes quotation marks with a backslash to make sure, they are
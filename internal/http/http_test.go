@@ -1,17 +1,23 @@
 package http_test
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
 	ahttp "github.com/OpenSlides/openslides-autoupdate-service/internal/http"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 )
 
@@ -21,18 +27,52 @@ var (
 )
 
 type connecterMock struct {
-	f autoupdate.DataProvider
+	f        autoupdate.DataProvider
+	position int
+
+	// noPosition lets WaitForPosition fail, like a datastore without
+	// positions.
+	noPosition bool
+
+	// kb is the keysbuilder of the last call to SingleData.
+	kb autoupdate.KeysBuilder
 }
 
 func (c *connecterMock) Connect(userID int, kb autoupdate.KeysBuilder) autoupdate.DataProvider {
 	return c.f
 }
 
+func (c *connecterMock) Multiplex(userID int) *autoupdate.Multiplexer {
+	// The multiplexer can not be mocked.
+	return nil
+}
+
 func (c *connecterMock) SingleData(ctx context.Context, userID int, kb autoupdate.KeysBuilder, position int) (map[dskey.Key][]byte, error) {
+	c.kb = kb
 	next, _ := c.f()
 	return next(ctx)
 }
 
+func (c *connecterMock) Position() int {
+	return c.position
+}
+
+func (c *connecterMock) PositionAt(ctx context.Context, t time.Time) (int, error) {
+	return c.position, nil
+}
+
+func (c *connecterMock) WaitForPosition(ctx context.Context, position int) error {
+	if position > c.position && c.noPosition {
+		return fmt.Errorf("waiting for position %d: %w", position, datastore.ErrNoPosition)
+	}
+
+	if position > c.position {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
 func TestKeysHandler(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -158,7 +198,7 @@ func TestErrors(t *testing.T) {
 				strings.NewReader("[]"),
 			),
 			400,
-			`SyntaxError`,
+			`syntax_error`,
 			`No data`,
 		},
 		{
@@ -169,7 +209,7 @@ func TestErrors(t *testing.T) {
 				strings.NewReader("{5"),
 			),
 			400,
-			`JsonError`,
+			`json_error`,
 			`invalid character '5' looking for beginning of object key string`,
 		},
 		{
@@ -180,7 +220,7 @@ func TestErrors(t *testing.T) {
 				strings.NewReader(`[{"ids":[123]}]`),
 			),
 			400,
-			`SyntaxError`,
+			`syntax_error`,
 			`attribute collection is missing`,
 		},
 		{
@@ -191,7 +231,7 @@ func TestErrors(t *testing.T) {
 				strings.NewReader(`{"ids":[1],"collection":"foo","fields":{}}`),
 			),
 			400,
-			`SyntaxError`,
+			`syntax_error`,
 			"wrong type at field ``. Got object, expected list",
 		},
 		{
@@ -202,7 +242,7 @@ func TestErrors(t *testing.T) {
 				strings.NewReader(`[{"ids":["1"],"collection":"foo","fields":{}}]`),
 			),
 			400,
-			`SyntaxError`,
+			`syntax_error`,
 			"wrong type at field `ids`. Got string, expected number",
 		},
 	} {
@@ -239,15 +279,32 @@ func TestErrors(t *testing.T) {
 }
 
 type HistoryInformationStub struct {
-	uid   int
-	fqid  string
-	write string
-	err   error
+	uid    int
+	fqid   string
+	filter autoupdate.HistoryFilter
+	write  string
+	err    error
+}
+
+func (h *HistoryInformationStub) PositionAt(ctx context.Context, t time.Time) (int, error) {
+	// Each second is one position.
+	return int(t.Unix()), nil
 }
 
-func (h *HistoryInformationStub) HistoryInformation(ctx context.Context, uid int, fqid string, w io.Writer) error {
+func (h *HistoryInformationStub) HistoryInformation(ctx context.Context, uid int, fqid string, filter autoupdate.HistoryFilter, w io.Writer) error {
 	h.uid = uid
 	h.fqid = fqid
+	h.filter = filter
+	if h.write != "" {
+		w.Write([]byte(h.write))
+	}
+	return h.err
+}
+
+func (h *HistoryInformationStub) HistoryInformationBatch(ctx context.Context, uid int, fqids []string, filter autoupdate.HistoryFilter, w io.Writer) error {
+	h.uid = uid
+	h.fqid = strings.Join(fqids, ",")
+	h.filter = filter
 	if h.write != "" {
 		w.Write([]byte(h.write))
 	}
@@ -283,6 +340,111 @@ func TestHistoryInformation(t *testing.T) {
 	}
 }
 
+func TestHistoryInformationAt(t *testing.T) {
+	mux := http.NewServeMux()
+	hi := &HistoryInformationStub{
+		write: "my information",
+	}
+	ahttp.HandleHistoryInformation(mux, fakeAuth(1), hi)
+
+	t.Run("at", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/system/autoupdate/history_information?fqid=motion/42&at=1000", nil)
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 200 {
+			t.Errorf("got status %d, expected 200", resp.Code)
+		}
+
+		if hi.filter.MaxPosition != 1000 {
+			t.Errorf("hi was called with max position %d, expected 1000", hi.filter.MaxPosition)
+		}
+	})
+
+	t.Run("at and position", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/system/autoupdate/history_information?fqid=motion/42&at=1000&position=5", nil)
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 400 {
+			t.Errorf("got status %d, expected 400", resp.Code)
+		}
+	})
+
+	t.Run("invalid at", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/system/autoupdate/history_information?fqid=motion/42&at=yesterday", nil)
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 400 {
+			t.Errorf("got status %d, expected 400", resp.Code)
+		}
+	})
+}
+
+func TestHistoryInformationBatch(t *testing.T) {
+	mux := http.NewServeMux()
+	hi := &HistoryInformationStub{
+		write: "my information",
+	}
+	ahttp.HandleHistoryInformation(mux, fakeAuth(1), hi)
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/system/autoupdate/history_information?fqids=motion/1,motion/2", nil)
+	mux.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Errorf("got status %d, expected 200", resp.Code)
+	}
+
+	if hi.fqid != "motion/1,motion/2" {
+		t.Errorf("hi was called with `%s`, expected `motion/1,motion/2`", hi.fqid)
+	}
+}
+
+func TestHistoryInformationFilter(t *testing.T) {
+	mux := http.NewServeMux()
+	hi := &HistoryInformationStub{
+		write: "my information",
+	}
+	ahttp.HandleHistoryInformation(mux, fakeAuth(1), hi)
+
+	for _, tt := range []struct {
+		name   string
+		query  string
+		expect autoupdate.HistoryFilter
+	}{
+		{"limit", "limit=10&before_position=20", autoupdate.HistoryFilter{Limit: 10, BeforePosition: 20}},
+		{"cursor", "limit=10&cursor=20", autoupdate.HistoryFilter{Limit: 10, BeforePosition: 20}},
+		{"user", "user_id=5", autoupdate.HistoryFilter{UserID: 5}},
+		{"time", "from_time=100&to_time=200", autoupdate.HistoryFilter{From: time.Unix(100, 0), To: time.Unix(200, 0)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/system/autoupdate/history_information?fqid=motion/42&"+tt.query, nil)
+			mux.ServeHTTP(resp, req)
+
+			if resp.Code != 200 {
+				t.Errorf("got status %d, expected 200", resp.Code)
+			}
+
+			if hi.filter != tt.expect {
+				t.Errorf("hi was called with filter %v, expected %v", hi.filter, tt.expect)
+			}
+		})
+	}
+
+	t.Run("negative limit", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/system/autoupdate/history_information?fqid=motion/42&limit=-1", nil)
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 400 {
+			t.Errorf("got status %d, expected 400", resp.Code)
+		}
+	})
+}
+
 func TestHistoryInformationNoFQID(t *testing.T) {
 	mux := http.NewServeMux()
 	hi := &HistoryInformationStub{
@@ -299,7 +461,7 @@ func TestHistoryInformationNoFQID(t *testing.T) {
 		t.Errorf("got status %s, expected %s", resp.Result().Status, http.StatusText(http.StatusBadRequest))
 	}
 
-	expect := `{"error": {"type": "invalid_request", "msg": "Invalid request: History Information needs an fqid"}}`
+	expect := `{"error": {"type": "invalid_request", "msg": "Invalid request: History Information needs an fqid", "retry": false}}`
 	if body, _ := io.ReadAll(resp.Result().Body); string(body) != expect {
 		t.Errorf("got body `%s`, expected `%s`", body, expect)
 	}
@@ -321,7 +483,7 @@ func TestHistoryInformationError(t *testing.T) {
 		t.Errorf("got status %s, expected %s", resp.Result().Status, http.StatusText(http.StatusInternalServerError))
 	}
 
-	expect := `{"error": {"type": "InternalError", "msg": "Something went wrong on the server. The admin is already informed."}}`
+	expect := `{"error": {"type": "internal_error", "msg": "Something went wrong on the server. The admin is already informed.", "retry": true}}`
 	if body, _ := io.ReadAll(resp.Result().Body); strings.TrimSpace(string(body)) != expect {
 		t.Errorf("got body `%s`, expected `%s`", body, expect)
 	}
@@ -340,3 +502,243 @@ func (a fakeAuth) Authenticate(w http.ResponseWriter, r *http.Request) (context.
 func (a fakeAuth) FromContext(ctx context.Context) int {
 	return int(a)
 }
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := true
+	f := func(ctx context.Context) (map[dskey.Key][]byte, error) {
+		if first {
+			first = false
+			return map[dskey.Key][]byte{myKey1: []byte(`"bar"`)}, nil
+		}
+
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	connecter := &connecterMock{
+		f: func() (func(ctx context.Context) (map[dskey.Key][]byte, error), bool) { return f, true },
+	}
+
+	mux := http.NewServeMux()
+	ahttp.HandleAutoupdate(mux, fakeAuth(1), connecter, nil, ahttp.WithHeartbeat(time.Millisecond))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/system/autoupdate?k=collection/1/field", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for i := 0; i < 3 && scanner.Scan(); i++ {
+		lines = append(lines, scanner.Text())
+	}
+
+	expect := []string{`{"collection/1/field":"bar"}`, `{}`, `{}`}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("got lines %v, expected %v", lines, expect)
+	}
+}
+
+func TestMinPosition(t *testing.T) {
+	f := func(ctx context.Context) (map[dskey.Key][]byte, error) {
+		return map[dskey.Key][]byte{myKey1: []byte(`"bar"`)}, nil
+	}
+
+	connecter := &connecterMock{
+		f:        func() (func(ctx context.Context) (map[dskey.Key][]byte, error), bool) { return f, true },
+		position: 5,
+	}
+
+	mux := http.NewServeMux()
+	ahttp.HandleAutoupdate(mux, fakeAuth(1), connecter, nil, ahttp.WithPositionTimeout(time.Millisecond))
+
+	t.Run("reached", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/system/autoupdate?k=collection/1/field&single&min_position=5", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != 200 {
+			t.Errorf("got status %d, expected 200", rec.Code)
+		}
+
+		expect := `{"collection/1/field":"bar"}` + "\n"
+		if got := rec.Body.String(); got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/system/autoupdate?k=collection/1/field&single&min_position=6", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("got status %d, expected %d", rec.Code, http.StatusServiceUnavailable)
+		}
+
+		if !strings.Contains(rec.Body.String(), `"type": "position_timeout"`) {
+			t.Errorf("got %s, expected a position_timeout error", rec.Body.String())
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		connecter := &connecterMock{
+			f:          func() (func(ctx context.Context) (map[dskey.Key][]byte, error), bool) { return f, true },
+			noPosition: true,
+		}
+
+		mux := http.NewServeMux()
+		ahttp.HandleAutoupdate(mux, fakeAuth(1), connecter, nil, ahttp.WithPositionTimeout(time.Minute))
+
+		req := httptest.NewRequest("GET", "/system/autoupdate?k=collection/1/field&single&min_position=6", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotImplemented {
+			t.Errorf("got status %d, expected %d", rec.Code, http.StatusNotImplemented)
+		}
+
+		if !strings.Contains(rec.Body.String(), `"type": "position_unknown"`) {
+			t.Errorf("got %s, expected a position_unknown error", rec.Body.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/system/autoupdate?k=collection/1/field&min_position=foo", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, expected %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestPreset(t *testing.T) {
+	dir := t.TempDir()
+	preset := `{"ids": ["$id"], "collection": "motion", "fields": {"title": null}}`
+	if err := os.WriteFile(filepath.Join(dir, "motion_detail.json"), []byte(preset), 0o600); err != nil {
+		t.Fatalf("writing preset: %v", err)
+	}
+
+	presets, err := keysbuilder.LoadPresets(dir)
+	if err != nil {
+		t.Fatalf("LoadPresets: %v", err)
+	}
+
+	f := func(ctx context.Context) (map[dskey.Key][]byte, error) {
+		return map[dskey.Key][]byte{myKey1: []byte(`"bar"`)}, nil
+	}
+	connecter := &connecterMock{
+		f: func() (func(ctx context.Context) (map[dskey.Key][]byte, error), bool) { return f, true },
+	}
+
+	mux := http.NewServeMux()
+	ahttp.HandleAutoupdate(mux, fakeAuth(1), connecter, nil, ahttp.WithPresets(presets))
+
+	t.Run("preset", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/system/autoupdate?preset=motion_detail&id=5&single", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != 200 {
+			t.Fatalf("got status %d, expected 200: %s", rec.Code, rec.Body.String())
+		}
+
+		if err := connecter.kb.Update(context.Background(), nil); err != nil {
+			t.Fatalf("updating keysbuilder: %v", err)
+		}
+
+		keys := connecter.kb.Keys()
+		if len(keys) != 1 || keys[0].String() != "motion/5/title" {
+			t.Errorf("got keys %v, expected [motion/5/title]", keys)
+		}
+	})
+
+	for _, tt := range []struct {
+		name  string
+		query string
+	}{
+		{"unknown", "preset=unknown&single"},
+		{"missing parameter", "preset=motion_detail&single"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/system/autoupdate?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, expected %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+type differStub struct {
+	from int
+	to   int
+	keys []dskey.Key
+}
+
+func (d *differStub) Diff(ctx context.Context, userID int, kb autoupdate.KeysBuilder, from, to int) (autoupdate.DataDiff, error) {
+	d.from = from
+	d.to = to
+
+	if err := kb.Update(ctx, nil); err != nil {
+		return autoupdate.DataDiff{}, err
+	}
+	d.keys = kb.Keys()
+
+	return autoupdate.DataDiff{
+		Added:   map[dskey.Key][]byte{myKey1: []byte(`"new"`)},
+		Removed: map[dskey.Key][]byte{},
+		Changed: map[dskey.Key]autoupdate.ValueChange{myKey2: {From: []byte(`"old"`), To: []byte(`"new"`)}},
+	}, nil
+}
+
+func TestDiff(t *testing.T) {
+	mux := http.NewServeMux()
+	differ := &differStub{}
+	ahttp.HandleDiff(mux, fakeAuth(1), differ)
+
+	t.Run("diff", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/system/autoupdate/diff?from=3&to=7&k=collection/1/field,collection/2/field", nil)
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 200 {
+			t.Errorf("got status %d, expected 200", resp.Code)
+		}
+
+		expect := `{"added":{"collection/1/field":"new"},"removed":{},"changed":{"collection/2/field":{"from":"old","to":"new"}}}` + "\n"
+		if got := resp.Body.String(); got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+
+		if differ.from != 3 || differ.to != 7 {
+			t.Errorf("differ was called with positions %d and %d, expected 3 and 7", differ.from, differ.to)
+		}
+
+		if len(differ.keys) != 2 {
+			t.Errorf("differ was called with keys %v, expected two keys", differ.keys)
+		}
+	})
+
+	t.Run("no positions", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/system/autoupdate/diff?k=collection/1/field", nil)
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 400 {
+			t.Errorf("got status %d, expected 400", resp.Code)
+		}
+	})
+}
@@ -16,3 +16,9 @@ type ClientError interface {
 	Type() string
 	Error() string
 }
+
+// Retryer is an error, that tells the client, if it can send the same request
+// again.
+type Retryer interface {
+	Retry() bool
+}
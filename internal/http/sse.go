@@ -0,0 +1,118 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// sseWriter writes each call to Write as one server-sent event.
+//
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html
+type sseWriter struct {
+	http.ResponseWriter
+
+	event string
+	id    uint64
+}
+
+func (w *sseWriter) Write(p []byte) (int, error) {
+	buf := new(bytes.Buffer)
+	if w.event != "" {
+		fmt.Fprintf(buf, "event: %s\n", w.event)
+	}
+
+	if w.id != 0 {
+		fmt.Fprintf(buf, "id: %d\n", w.id)
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		fmt.Fprintf(buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+
+	if _, err := w.ResponseWriter.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sseSince returns the topic id, after that the client wants the changes.
+//
+// The browser sends the id of the last event in the header Last-Event-ID when
+// it reconnects. Returns 0, if the client did not send an id.
+func sseSince(r *http.Request) (uint64, error) {
+	rawSince := r.Header.Get("Last-Event-ID")
+	if rawSince == "" {
+		rawSince = r.URL.Query().Get("since")
+	}
+
+	if rawSince == "" {
+		return 0, nil
+	}
+
+	since, err := strconv.ParseUint(rawSince, 10, 64)
+	if err != nil {
+		return 0, invalidRequestError{fmt.Errorf("last event id has to be a number, not %s", rawSince)}
+	}
+	return since, nil
+}
+
+// sendSSE sends the data as server-sent events. The id of each event is the
+// topic id of the data. It can be used to resume the stream.
+func sendSSE(ctx context.Context, w http.ResponseWriter, uid int, kb autoupdate.KeysBuilder, request string, since uint64, connecter MultiplexConnecter, enc encoding, heartbeat time.Duration) error {
+	multiplexer := connecter.Multiplex(uid)
+	multiplexer.Resume("", kb, request, since)
+
+	// A line starting with a colon is a comment. The browser ignores it.
+	sendHeartbeat := func() error {
+		if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+			return fmt.Errorf("write heartbeat: %w", err)
+		}
+		w.(http.Flusher).Flush()
+		return nil
+	}
+
+	var lineEncoder *zstd.Encoder
+	if enc.compress {
+		var err error
+		lineEncoder, err = newZstdLineEncoder()
+		if err != nil {
+			return fmt.Errorf("creating zstd encoder: %w", err)
+		}
+		defer lineEncoder.Close()
+	}
+
+	next := multiplexer.Next
+	for f, ok := next(); ok; f, ok = next() {
+		// This blocks, until there is new data. It also unblocks, when the
+		// client context is done.
+		data, err := waitWithHeartbeat(ctx, heartbeat, f, sendHeartbeat)
+		if err != nil {
+			return fmt.Errorf("getting next message: %w", err)
+		}
+
+		if err := data.Errors[""]; err != nil {
+			return fmt.Errorf("getting next message: %w", err)
+		}
+
+		// The data has to be written with one call to Write to be one event.
+		buf := new(bytes.Buffer)
+		if err := writeJSONLine(buf, enc.jsonFrame(data.Subscriptions[""], data.Position), lineEncoder); err != nil {
+			return fmt.Errorf("write data: %w", err)
+		}
+
+		if _, err := (&sseWriter{ResponseWriter: w, id: data.ID}).Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("write event: %w", err)
+		}
+		w.(http.Flusher).Flush()
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,131 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/OpenSlides/openslides-autoupdate-service/internal/http"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
+)
+
+func TestSSE(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		dskey.MustKey("user/1/name"): []byte(`"bar"`),
+	})
+	go bg(ctx, oserror.Handle)
+
+	allowAll := func(getter datastore.Getter, uid int) datastore.Getter { return getter }
+	service, _ := autoupdate.New(ds, allowAll)
+
+	mux := http.NewServeMux()
+	ahttp.HandleAutoupdate(mux, fakeAuth(1), service, nil)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("first event", func(t *testing.T) {
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		defer reqCancel()
+
+		req, _ := http.NewRequestWithContext(reqCtx, "GET", srv.URL+"/system/autoupdate?k=user/1/name&format=sse", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("got content type %s, expected text/event-stream", got)
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				break
+			}
+			lines = append(lines, scanner.Text())
+		}
+
+		if len(lines) != 2 {
+			t.Fatalf("got event %v, expected two lines", lines)
+		}
+
+		if !strings.HasPrefix(lines[0], "id: ") {
+			t.Errorf("first line of event is %q, expected an id", lines[0])
+		}
+
+		if expect := `data: {"user/1/name":"bar"}`; lines[1] != expect {
+			t.Errorf("got data line %q, expected %q", lines[1], expect)
+		}
+	})
+
+	t.Run("invalid last event id", func(t *testing.T) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/system/autoupdate?k=user/1/name&format=sse", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 400 {
+			t.Errorf("got status %s, expected 400", resp.Status)
+		}
+	})
+}
+
+func TestSSEError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		dskey.MustKey("user/1/name"): []byte(`"bar"`),
+	})
+	go bg(ctx, oserror.Handle)
+
+	allowAll := func(getter datastore.Getter, uid int) datastore.Getter { return getter }
+	service, _ := autoupdate.New(ds, allowAll)
+
+	mux := http.NewServeMux()
+	ahttp.HandleAutoupdate(mux, fakeAuth(1), service, nil)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ds.InjectError(errors.New("datastore is broken"))
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/system/autoupdate?k=user/1/name&format=sse", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+
+	if got := strings.Count(string(body), "event: error"); got != 1 {
+		t.Fatalf("got %d error events, expected 1: %s", got, body)
+	}
+
+	expect := "event: error\ndata: {\"error\": {\"type\": \"internal_error\", "
+	if !strings.HasPrefix(string(body), expect) {
+		t.Errorf("got body %q, expected it to start with %q", body, expect)
+	}
+}
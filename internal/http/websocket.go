@@ -0,0 +1,397 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/metric"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"golang.org/x/net/websocket"
+)
+
+// Message types that are used in the websocket protocol.
+const (
+	wsTypeKeys        = "keys"
+	wsTypeSubscribe   = "subscribe"
+	wsTypeChange      = "change"
+	wsTypeUnsubscribe = "unsubscribe"
+	wsTypeAck         = "ack"
+	wsTypeData        = "data"
+	wsTypeError       = "error"
+	wsTypeHeartbeat   = "heartbeat"
+)
+
+// Subprotocols of the websocket.
+//
+// A browser can not set the Authentication header on a websocket. It can send
+// the auth token as an additional subprotocol with the prefix wsTokenPrefix.
+const (
+	wsProtocol    = "openslides.autoupdate"
+	wsTokenPrefix = "bearer."
+)
+
+// wsClientMessage is a message from the client to the server.
+type wsClientMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Request json.RawMessage `json:"request"`
+	Since   uint64          `json:"since"`
+}
+
+// wsServerMessage is a message from the server to the client.
+type wsServerMessage struct {
+	Type         string        `json:"type"`
+	ID           uint64        `json:"id,omitempty"`
+	Position     int           `json:"position,omitempty"`
+	Subscription string        `json:"subscription,omitempty"`
+	Data         any           `json:"data,omitempty"`
+	Error        *errorMessage `json:"error,omitempty"`
+}
+
+// MultiplexConnecter creates connections with many subscriptions.
+type MultiplexConnecter interface {
+	Multiplex(userID int) *autoupdate.Multiplexer
+}
+
+// HandleWebsocket registers the websocket route for the autoupdate service.
+//
+// It uses the same keysbuilder format as HandleAutoupdate. The client sends
+// its keys requests as messages over the socket and receives the data as
+// messages. A client can have many subscriptions on one websocket.
+func HandleWebsocket(mux *http.ServeMux, auth Authenticater, connecter MultiplexConnecter, counter *metric.CurrentCounter, options ...Option) {
+	cfg := newConfig(options)
+
+	handler := websocket.Server{
+		// The auth token is read from the refreshId cookie. So a connection
+		// from a foreign website has to be rejected.
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if err := checkOrigin(r, cfg.allowedOrigins); err != nil {
+				return err
+			}
+			return selectProtocol(config)
+		},
+
+		Handler: func(ws *websocket.Conn) {
+			r := ws.Request()
+			uid := auth.FromContext(r.Context())
+
+			multiplexer := connecter.Multiplex(uid)
+
+			if rawKeys := r.URL.Query().Get("k"); rawKeys != "" {
+				queryBuilder, err := keysbuilder.FromKeys(strings.Split(rawKeys, ",")...)
+				if err != nil {
+					wsSendError(ws, "", fmt.Errorf("building keysbuilder from query: %w", err))
+					return
+				}
+				queryBuilder.SetLimits(cfg.keysLimits)
+
+				var since uint64
+				if rawSince := r.URL.Query().Get("since"); rawSince != "" {
+					since, err = strconv.ParseUint(rawSince, 10, 64)
+					if err != nil {
+						wsSendError(ws, "", invalidRequestError{fmt.Errorf("since has to be a number, not %s", rawSince)})
+						return
+					}
+				}
+
+				multiplexer.Resume("", queryBuilder, "k="+rawKeys, since)
+			}
+
+			withAck := r.URL.Query().Has("ack")
+
+			if err := serveWebsocket(r.Context(), ws, multiplexer, withAck, cfg.heartbeat, cfg.keysLimits); err != nil {
+				wsSendError(ws, "", err)
+			}
+		},
+	}
+
+	mux.Handle(
+		prefixPublic+"/websocket",
+		validRequest(
+			wsTokenMiddleware(
+				authMiddleware(
+					countMiddleware(
+						handler,
+						counter,
+					),
+					auth,
+				),
+			),
+		),
+	)
+}
+
+// checkOrigin returns an error, if the request comes from a foreign website.
+//
+// Requests without the Origin header do not come from a browser and are
+// allowed. Otherwise the origin has to be the host of the request or one of
+// the allowed origins.
+func checkOrigin(r *http.Request, allowed []string) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("parsing origin %s: %w", origin, err)
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// selectProtocol sets the subprotocol of the response. The server only answers
+// with wsProtocol and never with the token.
+func selectProtocol(config *websocket.Config) error {
+	offered := config.Protocol
+	config.Protocol = nil
+
+	var withToken bool
+	for _, protocol := range offered {
+		switch {
+		case protocol == wsProtocol:
+			config.Protocol = []string{wsProtocol}
+		case strings.HasPrefix(protocol, wsTokenPrefix):
+			withToken = true
+		}
+	}
+
+	if withToken && config.Protocol == nil {
+		return fmt.Errorf("the subprotocol %s needs the subprotocol %s", wsTokenPrefix, wsProtocol)
+	}
+	return nil
+}
+
+// wsTokenMiddleware copies the auth token from the subprotocols of the
+// websocket to the Authentication header.
+func wsTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authentication") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+			for _, protocol := range strings.Split(value, ",") {
+				protocol = strings.TrimSpace(protocol)
+				if strings.HasPrefix(protocol, wsTokenPrefix) {
+					r = r.Clone(r.Context())
+					r.Header.Set("Authentication", "bearer "+strings.TrimPrefix(protocol, wsTokenPrefix))
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveWebsocket reads the messages from the client and sends the data for
+// all subscriptions of the client.
+//
+// Blocks until the client closes the connection, the context is done or
+// sending the data fails.
+func serveWebsocket(ctx context.Context, ws *websocket.Conn, multiplexer *autoupdate.Multiplexer, withAck bool, heartbeat time.Duration, limits keysbuilder.Limits) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	messages := make(chan []byte)
+	go func() {
+		// If the client closes the connection, the reading fails and the
+		// context gets canceled.
+		defer cancel()
+
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var acks chan struct{}
+	if withAck {
+		acks = make(chan struct{}, 1)
+	}
+
+	streamDone := make(chan error, 1)
+	go func() {
+		streamDone <- wsSendData(ctx, ws, multiplexer, acks, heartbeat)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-streamDone:
+			if err != nil {
+				return fmt.Errorf("sending data: %w", err)
+			}
+			return nil
+
+		case rawMsg := <-messages:
+			var msg wsClientMessage
+			if err := json.Unmarshal(rawMsg, &msg); err != nil {
+				wsSendError(ws, "", invalidRequestError{fmt.Errorf("decoding message: %w", err)})
+				continue
+			}
+
+			switch msg.Type {
+			case wsTypeKeys, wsTypeSubscribe, wsTypeChange:
+				if msg.Type == wsTypeKeys {
+					// A keys message is a subscription without an id.
+					msg.ID = ""
+				}
+
+				newKB, err := keysbuilder.ManyFromJSON(bytes.NewReader(msg.Request))
+				if err != nil {
+					wsSendError(ws, msg.ID, fmt.Errorf("building keysbuilder from message: %w", err))
+					continue
+				}
+				newKB.SetLimits(limits)
+
+				if msg.Type == wsTypeChange {
+					multiplexer.Change(msg.ID, newKB)
+					continue
+				}
+
+				multiplexer.Resume(msg.ID, newKB, wsRequestID(msg), msg.Since)
+
+			case wsTypeUnsubscribe:
+				multiplexer.Unsubscribe(msg.ID)
+
+			case wsTypeAck:
+				select {
+				case acks <- struct{}{}:
+				default:
+				}
+
+			default:
+				wsSendError(ws, "", invalidRequestError{fmt.Errorf("unknown message type %q", msg.Type)})
+			}
+		}
+	}
+}
+
+// wsSendData sends the data of all subscriptions to the client. Each
+// subscription with new data gets its own message.
+//
+// If acks is not nil, wsSendData waits after each update until the client
+// acknowledges it. The changes in the meantime are combined.
+func wsSendData(ctx context.Context, ws *websocket.Conn, multiplexer *autoupdate.Multiplexer, acks <-chan struct{}, heartbeat time.Duration) error {
+	sendHeartbeat := func() error {
+		if err := websocket.JSON.Send(ws, wsServerMessage{Type: wsTypeHeartbeat}); err != nil {
+			return fmt.Errorf("sending heartbeat: %w", err)
+		}
+		return nil
+	}
+
+	next := multiplexer.Next
+
+	for f, ok := next(); ok; f, ok = next() {
+		// This blocks, until there is new data. It also unblocks, when the
+		// connection is closed.
+		data, err := waitWithHeartbeat(ctx, heartbeat, f, sendHeartbeat)
+		if err != nil {
+			if oserror.ContextDone(err) {
+				return nil
+			}
+			return fmt.Errorf("getting next message: %w", err)
+		}
+
+		// The other subscriptions keep running.
+		for id, err := range data.Errors {
+			wsSendError(ws, id, err)
+		}
+
+		for id, subData := range data.Subscriptions {
+			msg := wsServerMessage{
+				Type:         wsTypeData,
+				ID:           data.ID,
+				Position:     data.Position,
+				Subscription: id,
+				Data:         convertData(subData),
+			}
+
+			if err := websocket.JSON.Send(ws, msg); err != nil {
+				return fmt.Errorf("sending message: %w", err)
+			}
+		}
+
+		if acks != nil {
+			select {
+			case <-acks:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// wsRequestID returns a string to identify the request of a subscribe message.
+// It is used to resume the subscription on another websocket.
+func wsRequestID(msg wsClientMessage) string {
+	request := new(bytes.Buffer)
+	if err := json.Compact(request, msg.Request); err != nil {
+		// The request was already parsed by the keysbuilder, so this should
+		// not happen.
+		return ""
+	}
+
+	return msg.ID + "\n" + request.String()
+}
+
+// wsSendError sends an error message to the client. The subscription id can be
+// empty, if the error does not belong to a subscription.
+func wsSendError(ws *websocket.Conn, subscription string, err error) {
+	if oserror.ContextDone(err) || errors.Is(err, io.EOF) {
+		// Client closed connection.
+		return
+	}
+
+	msg := newErrorMessage(err)
+
+	// If the connection is broken, there is nothing to do about it.
+	_ = websocket.JSON.Send(ws, wsServerMessage{Type: wsTypeError, Subscription: subscription, Error: &msg})
+}
+
+// convertData converts the data from the datastore to a format that can be
+// encoded to json.
+func convertData(data map[dskey.Key][]byte) map[string]json.RawMessage {
+	converted := make(map[string]json.RawMessage, len(data))
+	for k, v := range data {
+		converted[k.String()] = v
+	}
+	return converted
+}
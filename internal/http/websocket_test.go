@@ -0,0 +1,272 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/OpenSlides/openslides-autoupdate-service/internal/http"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
+	"golang.org/x/net/websocket"
+)
+
+func dialWebsocket(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/system/autoupdate/websocket" + query
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	return ws
+}
+
+type wsMessage struct {
+	Type         string                     `json:"type"`
+	ID           uint64                     `json:"id"`
+	Subscription string                     `json:"subscription"`
+	Data         map[string]json.RawMessage `json:"data"`
+	Error        struct {
+		Type string `json:"type"`
+		Msg  string `json:"msg"`
+	} `json:"error"`
+}
+
+func receiveWS(t *testing.T, ws *websocket.Conn) wsMessage {
+	t.Helper()
+
+	var msg wsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return msg
+}
+
+func TestWebsocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nameKey := dskey.MustKey("user/1/name")
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		nameKey: []byte(`"bar"`),
+	})
+	go bg(ctx, oserror.Handle)
+
+	allowAll := func(getter datastore.Getter, uid int) datastore.Getter { return getter }
+	service, _ := autoupdate.New(ds, allowAll)
+
+	mux := http.NewServeMux()
+	ahttp.HandleWebsocket(mux, fakeAuth(1), service, nil)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("keys from query", func(t *testing.T) {
+		ws := dialWebsocket(t, srv, "?k=user/1/name")
+		defer ws.Close()
+
+		msg := receiveWS(t, ws)
+
+		if msg.Type != "data" {
+			t.Errorf("got message type %s, expected data", msg.Type)
+		}
+
+		if msg.ID == 0 {
+			t.Errorf("data message has no id")
+		}
+
+		if got := string(msg.Data[nameKey.String()]); got != `"bar"` {
+			t.Errorf("got value %s, expected \"bar\"", got)
+		}
+	})
+
+	t.Run("keys from message", func(t *testing.T) {
+		ws := dialWebsocket(t, srv, "")
+		defer ws.Close()
+
+		for i := 0; i < 2; i++ {
+			request := `{"type":"keys","request":[{"ids":[1],"collection":"user","fields":{"name":null}}]}`
+			if err := websocket.Message.Send(ws, request); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			msg := receiveWS(t, ws)
+
+			if got := string(msg.Data[nameKey.String()]); got != `"bar"` {
+				t.Errorf("message %d: got value %s, expected \"bar\"", i, got)
+			}
+		}
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		ws := dialWebsocket(t, srv, "")
+		defer ws.Close()
+
+		for _, id := range []string{"first", "second"} {
+			request := `{"type":"subscribe","id":"` + id + `","request":[{"ids":[1],"collection":"user","fields":{"name":null}}]}`
+			if err := websocket.Message.Send(ws, request); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			msg := receiveWS(t, ws)
+
+			if msg.Subscription != id {
+				t.Errorf("got message for subscription %q, expected %q", msg.Subscription, id)
+			}
+
+			if got := string(msg.Data[nameKey.String()]); got != `"bar"` {
+				t.Errorf("subscription %s: got value %s, expected \"bar\"", id, got)
+			}
+		}
+
+		if err := websocket.Message.Send(ws, `{"type":"unsubscribe","id":"first"}`); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+
+		// Make sure, the unsubscribe message is handled before the update.
+		if err := websocket.Message.Send(ws, `{"type":"subscribe","id":"third","request":[{"ids":[2],"collection":"user","fields":{"name":null}}]}`); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		if msg := receiveWS(t, ws); msg.Subscription != "third" {
+			t.Fatalf("got message for subscription %q, expected \"third\"", msg.Subscription)
+		}
+
+		ds.Send(map[dskey.Key][]byte{nameKey: []byte(`"new"`)})
+
+		msg := receiveWS(t, ws)
+
+		if msg.Subscription != "second" {
+			t.Errorf("got message for subscription %q, expected \"second\"", msg.Subscription)
+		}
+
+		if got := string(msg.Data[nameKey.String()]); got != `"new"` {
+			t.Errorf("got value %s, expected \"new\"", got)
+		}
+	})
+
+	t.Run("invalid since", func(t *testing.T) {
+		ws := dialWebsocket(t, srv, "?k=user/1/name&since=abc")
+		defer ws.Close()
+
+		msg := receiveWS(t, ws)
+
+		if msg.Type != "error" || msg.Error.Type != "invalid_request" {
+			t.Errorf("got message %v, expected an invalid_request error", msg)
+		}
+	})
+
+	t.Run("invalid keys request", func(t *testing.T) {
+		ws := dialWebsocket(t, srv, "")
+		defer ws.Close()
+
+		if err := websocket.Message.Send(ws, `{"type":"subscribe","id":"broken","request":[{"ids":[1]}]}`); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+
+		msg := receiveWS(t, ws)
+
+		if msg.Type != "error" || msg.Error.Type != "syntax_error" {
+			t.Errorf("got message %v, expected a syntax_error", msg)
+		}
+
+		if msg.Subscription != "broken" {
+			t.Errorf("got error for subscription %q, expected \"broken\"", msg.Subscription)
+		}
+	})
+}
+
+// headerAuth authenticates the user 1, if the Authentication header has the
+// expected value.
+type headerAuth string
+
+func (a headerAuth) Authenticate(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	if r.Header.Get("Authentication") != string(a) {
+		return nil, errors.New("wrong token")
+	}
+	return r.Context(), nil
+}
+
+func (a headerAuth) FromContext(ctx context.Context) int {
+	return 1
+}
+
+func TestWebsocketHandshake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds, bg := dsmock.NewMockDatastore(map[dskey.Key][]byte{
+		dskey.MustKey("user/1/name"): []byte(`"bar"`),
+	})
+	go bg(ctx, oserror.Handle)
+
+	allowAll := func(getter datastore.Getter, uid int) datastore.Getter { return getter }
+	service, _ := autoupdate.New(ds, allowAll)
+
+	mux := http.NewServeMux()
+	ahttp.HandleWebsocket(mux, headerAuth("bearer my-token"), service, nil, ahttp.WithAllowedOrigins([]string{"https://allowed.example"}))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/system/autoupdate/websocket?k=user/1/name"
+
+	dial := func(origin string, protocols ...string) (*websocket.Conn, error) {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Fatalf("creating config: %v", err)
+		}
+		config.Protocol = protocols
+		return websocket.DialConfig(config)
+	}
+
+	t.Run("token as subprotocol", func(t *testing.T) {
+		ws, err := dial(srv.URL, "openslides.autoupdate", "bearer.my-token")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer ws.Close()
+
+		if got := ws.Config().Protocol; len(got) != 1 || got[0] != "openslides.autoupdate" {
+			t.Errorf("got protocol %v, expected [openslides.autoupdate]", got)
+		}
+
+		if msg := receiveWS(t, ws); msg.Type != "data" {
+			t.Errorf("got message type %s, expected data", msg.Type)
+		}
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		if _, err := dial(srv.URL, "openslides.autoupdate", "bearer.other-token"); err == nil {
+			t.Errorf("dial with a wrong token did not fail")
+		}
+	})
+
+	t.Run("token without protocol", func(t *testing.T) {
+		if _, err := dial(srv.URL, "bearer.my-token"); err == nil {
+			t.Errorf("dial without the subprotocol openslides.autoupdate did not fail")
+		}
+	})
+
+	t.Run("allowed origin", func(t *testing.T) {
+		ws, err := dial("https://allowed.example", "openslides.autoupdate", "bearer.my-token")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		ws.Close()
+	})
+
+	t.Run("foreign origin", func(t *testing.T) {
+		if _, err := dial("https://evil.example", "openslides.autoupdate", "bearer.my-token"); err == nil {
+			t.Errorf("dial from a foreign origin did not fail")
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package http
+
+//go:generate  sh -c "go run gen_zstd_dictionary/main.go zstd_dictionary"
+
+import (
+	"bytes"
+	_ "embed" // Needed for the embedded dictionary.
+	"fmt"
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdWindowSize is the window size of the zstd streams. The streams are
+// kept for the whole connection, so a bigger value means more memory per
+// connection.
+const zstdWindowSize = 1 << 20
+
+// zstdDict is a zstd dictionary for the keys from models.yml. It is trained
+// with the zstd command line tool from messages like the service sends them.
+//
+//go:embed zstd_dictionary
+var zstdDict []byte
+
+// newZstdStream creates a zstd encoder for a connection.
+func newZstdStream(w *bytes.Buffer, withDictionary bool) (*zstd.Encoder, error) {
+	options := []zstd.EOption{
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(zstdWindowSize),
+		zstd.WithLowerEncoderMem(true),
+	}
+
+	if withDictionary {
+		// The default level does not find most of the matches in the
+		// dictionary.
+		options = append(options, zstd.WithEncoderDict(zstdDict), zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	}
+
+	encoder, err := zstd.NewWriter(w, options...)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd encoder: %w", err)
+	}
+	return encoder, nil
+}
+
+// newZstdLineEncoder creates a zstd encoder, that compresses each line of a
+// connection with EncodeAll. Each line is an independent zstd frame.
+func newZstdLineEncoder() (*zstd.Encoder, error) {
+	encoder, err := zstd.NewWriter(
+		nil,
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithLowerEncoderMem(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd encoder: %w", err)
+	}
+	return encoder, nil
+}
+
+// HandleZstdDictionary registers the route, that returns the zstd dictionary.
+//
+// The client needs the dictionary to decode the data, if it uses the query
+// parameter `compress=dict`.
+func HandleZstdDictionary(mux *http.ServeMux) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err := w.Write(zstdDict); err != nil {
+			handleErrorWithoutStatus(w, fmt.Errorf("writing dictionary: %w", err))
+		}
+	})
+
+	mux.Handle(prefixPublic+"/zstd_dictionary", handler)
+}
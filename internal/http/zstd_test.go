@@ -0,0 +1,64 @@
+package http
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestZstdDictionaryReferenceDecoder(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd command line tool is not installed")
+	}
+
+	dictFile := filepath.Join(t.TempDir(), "dictionary")
+	if err := os.WriteFile(dictFile, zstdDict, 0o600); err != nil {
+		t.Fatalf("writing dictionary: %v", err)
+	}
+
+	content := []byte(`{"motion/1/title":"my motion","motion/1/submitter_ids":[1,2],"motion/1/state_id":5}` + "\n")
+
+	buf := new(bytes.Buffer)
+	stream, err := newZstdStream(buf, true)
+	if err != nil {
+		t.Fatalf("creating stream: %v", err)
+	}
+
+	if _, err := stream.Write(content); err != nil {
+		t.Fatalf("writing content: %v", err)
+	}
+
+	if err := stream.Close(); err != nil {
+		t.Fatalf("closing stream: %v", err)
+	}
+
+	cmd := exec.Command("zstd", "-d", "-q", "-c", "-D", dictFile)
+	cmd.Stdin = bytes.NewReader(buf.Bytes())
+	decoded, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("decoding with zstd: %v", err)
+	}
+
+	if !bytes.Equal(decoded, content) {
+		t.Errorf("got %q, expected %q", decoded, content)
+	}
+
+	withoutDict := new(bytes.Buffer)
+	plainStream, err := newZstdStream(withoutDict, false)
+	if err != nil {
+		t.Fatalf("creating stream without dictionary: %v", err)
+	}
+	if _, err := plainStream.Write(content); err != nil {
+		t.Fatalf("writing content without dictionary: %v", err)
+	}
+
+	if err := plainStream.Close(); err != nil {
+		t.Fatalf("closing stream without dictionary: %v", err)
+	}
+
+	if buf.Len() >= withoutDict.Len() {
+		t.Errorf("compressed content has %d bytes with the dictionary and %d bytes without it, expected it to be smaller", buf.Len(), withoutDict.Len())
+	}
+}
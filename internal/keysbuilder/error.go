@@ -25,7 +25,7 @@ func (e InvalidError) Error() string {
 
 // Type returns the name of the error.
 func (e InvalidError) Type() string {
-	return "SyntaxError"
+	return "syntax_error"
 }
 
 // Fields returns a list of field names from the parent to this error.
@@ -66,7 +66,7 @@ func (e JSONError) Unwrap() error {
 
 // Type returns the name of the error.
 func (e JSONError) Type() string {
-	return "JsonError"
+	return "json_error"
 }
 
 // ValueError in returned by keysbuilder.Update(), when the value of a key has
@@ -84,10 +84,32 @@ func (e ValueError) Error() string {
 
 // Type returns the name of the error.
 func (e ValueError) Type() string {
-	return "ValueError"
+	return "value_error"
 }
 
 // Unwrap returns the thrown error.
 func (e ValueError) Unwrap() error {
 	return e.err
 }
+
+// LimitError is returned by keysbuilder.Update(), when the request exceeds one
+// of the limits.
+type LimitError struct {
+	limit string
+	max   int
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("the keys request is too complex. It exceeds the limit of %d %s", e.max, e.limit)
+}
+
+// Type returns the name of the error.
+func (e LimitError) Type() string {
+	return "limit_error"
+}
+
+// Limit returns the name of the exceeded limit. It is one of depth, keys or
+// iterations.
+func (e LimitError) Limit() string {
+	return e.limit
+}
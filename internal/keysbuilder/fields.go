@@ -20,11 +20,13 @@ build method only returns, when all sub-jobs are done.
 package keysbuilder
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
 
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 )
 
@@ -34,6 +36,8 @@ const (
 	ftGenericRelation     = "generic-relation"
 	ftGenericRelationList = "generic-relation-list"
 	ftTemplate            = "template"
+
+	fieldWildcard = "*"
 )
 
 var (
@@ -92,11 +96,14 @@ func (b *body) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (b *body) keys(data map[dskey.Key]fieldDescription) {
+func (b *body) keys(data map[dskey.Key]fieldDescription) error {
 	for _, id := range b.ids {
 		cid := buildCollectionID(b.collection, id)
-		b.fieldsMap.keys(cid, data)
+		if err := b.fieldsMap.keys(cid, data); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // relationField is a fieldtype that redirects to one other collection.
@@ -146,8 +153,7 @@ func (r *relationField) keys(key dskey.Key, value json.RawMessage, data map[dske
 	}
 
 	cid := buildCollectionID(r.collection, id)
-	r.fieldsMap.keys(cid, data)
-	return nil
+	return r.fieldsMap.keys(cid, data)
 }
 
 // relationListField is a fieldtype like relation, but redirects to a list of objects.
@@ -159,12 +165,26 @@ func (r *relationField) keys(key dskey.Key, value json.RawMessage, data map[dske
 //			"group_ids": {
 //				"type": "relation-list",
 //				"collection": "group",
-//				"fields": {"name": null}
+//				"fields": {"name": null},
+//				"filter": {"field": "weight", "in": [1, 2]},
+//				"order_by": "name",
+//				"limit": 10
 //			}
 //		}
 //	}
+//
+// The filter and the other options are optional. See listOptions.
 type relationListField struct {
 	relationField
+	list listOptions
+}
+
+func (r *relationListField) UnmarshalJSON(data []byte) error {
+	if err := r.relationField.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &r.list)
 }
 
 func (r *relationListField) keys(key dskey.Key, value json.RawMessage, data map[dskey.Key]fieldDescription) error {
@@ -173,13 +193,12 @@ func (r *relationListField) keys(key dskey.Key, value json.RawMessage, data map[
 		return fmt.Errorf("decoding value for key %s: %w", key, err)
 	}
 
-	for _, id := range ids {
-		cid := buildCollectionID(r.collection, id)
-		for field, description := range r.fields {
-			data[buildGenericKey(cid, field)] = description
-		}
+	cids := make([]string, len(ids))
+	for i, id := range ids {
+		cids[i] = buildCollectionID(r.collection, id)
 	}
-	return nil
+
+	return r.list.keys(cids, &r.fieldsMap, data)
 }
 
 // genericRelationField is like a relationField but the collection is given from the restricter.
@@ -218,8 +237,7 @@ func (g *genericRelationField) keys(key dskey.Key, value json.RawMessage, data m
 		return fmt.Errorf("decoding value for key %s: %w", key, err)
 	}
 
-	g.fieldsMap.keys(cid, data)
-	return nil
+	return g.fieldsMap.keys(cid, data)
 }
 
 // genericRelationListField is like a genericRelationField but with a list of relations.
@@ -230,12 +248,24 @@ func (g *genericRelationField) keys(key dskey.Key, value json.RawMessage, data m
 //		"fields": {
 //			"seen": {
 //				"type": "generic-relation-list",
-//				"fields": {"name": null}
+//				"fields": {"name": null},
+//				"filter": {"field": "published", "equals": true}
 //			}
 //		}
 //	}
+//
+// The filter and the other options are optional. See listOptions.
 type genericRelationListField struct {
 	genericRelationField
+	list listOptions
+}
+
+func (g *genericRelationListField) UnmarshalJSON(data []byte) error {
+	if err := g.genericRelationField.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &g.list)
 }
 
 func (g *genericRelationListField) keys(key dskey.Key, value json.RawMessage, data map[dskey.Key]fieldDescription) error {
@@ -244,12 +274,70 @@ func (g *genericRelationListField) keys(key dskey.Key, value json.RawMessage, da
 		return fmt.Errorf("decoding value for key %s: %w", key, err)
 	}
 
-	for _, cid := range cids {
-		g.fieldsMap.keys(cid, data)
+	return g.list.keys(cids, &g.fieldsMap, data)
+}
+
+// multiField is the description of a key, that is requested by more then one
+// field. For example by the client and by a filter.
+type multiField []fieldDescription
+
+func (m multiField) keys(key dskey.Key, value json.RawMessage, data map[dskey.Key]fieldDescription) error {
+	for _, description := range m {
+		if description == nil {
+			continue
+		}
+
+		if err := description.keys(key, value, data); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// addKey adds a key with its description to data. If the key already has a
+// description, both are used.
+//
+// The description can not be a multiField.
+func addKey(data map[dskey.Key]fieldDescription, key dskey.Key, description fieldDescription) {
+	existing, ok := data[key]
+	if !ok {
+		data[key] = description
+		return
+	}
+
+	multi, ok := existing.(multiField)
+	if !ok {
+		multi = multiField{existing}
+	}
+
+	for _, d := range multi {
+		if d == description {
+			// The same description, for example from a duplicate id in a
+			// list, would build the same keys.
+			return
+		}
+	}
+	data[key] = append(multi, description)
+}
+
+// hiddenField returns true, if the key of the description is only needed to
+// build other keys.
+func hiddenField(description fieldDescription) bool {
+	switch d := description.(type) {
+	case *listItem:
+		return true
+	case multiField:
+		for _, sub := range d {
+			if !hiddenField(sub) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 // templateField requests a list of fields from a template.
 //
 //	{
@@ -301,7 +389,7 @@ func (t *templateField) keys(key dskey.Key, value json.RawMessage, data map[dske
 	for _, value := range values {
 		newkey := key
 		newkey.Field = strings.Replace(key.Field, "$", "$"+value, 1)
-		data[newkey] = t.values
+		addKey(data, newkey, t.values)
 	}
 	return nil
 }
@@ -356,11 +444,35 @@ func unmarshalField(data []byte) (fieldDescription, error) {
 //
 // A fieldsMap knows how to be decoded from json and how to build the keys from
 // it.
+//
+// With the wildcard "*", all fields of the collection from the models.yml are
+// requested. Fields can be excluded from the wildcard:
+//
+//	"fields": "*"
+//	"fields": {"*": null, "group_ids": {"type": "relation-list", ...}}
+//	"fields": {"*": {"exclude": ["password"]}}
 type fieldsMap struct {
 	fields map[string]fieldDescription
+
+	wildcard bool
+	exclude  map[string]bool
 }
 
 func (f *fieldsMap) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '"' {
+		var wildcard string
+		if err := json.Unmarshal(data, &wildcard); err != nil {
+			return fmt.Errorf("decode fields: %w", err)
+		}
+		if wildcard != fieldWildcard {
+			return InvalidError{msg: fmt.Sprintf("fields has to be an object or %q", fieldWildcard)}
+		}
+
+		f.fields = make(map[string]fieldDescription)
+		f.wildcard = true
+		return nil
+	}
+
 	var fm map[string]json.RawMessage
 	if err := json.Unmarshal(data, &fm); err != nil {
 		return fmt.Errorf("decode fields: %w", err)
@@ -368,6 +480,13 @@ func (f *fieldsMap) UnmarshalJSON(data []byte) error {
 
 	f.fields = make(map[string]fieldDescription, len(fm))
 	for name, field := range fm {
+		if name == fieldWildcard {
+			if err := f.unmarshalWildcard(field); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if !reField.MatchString(name) {
 			return InvalidError{msg: fmt.Sprintf("fieldname %q is not a valid fieldname", name), field: name}
 		}
@@ -384,8 +503,60 @@ func (f *fieldsMap) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (f *fieldsMap) keys(cid string, data map[dskey.Key]fieldDescription) {
+// unmarshalWildcard decodes the value of the wildcard field. It can be null or
+// an object with a list of fields to exclude.
+func (f *fieldsMap) unmarshalWildcard(data []byte) error {
+	var wildcard *struct {
+		Exclude []string `json:"exclude"`
+	}
+	if err := json.Unmarshal(data, &wildcard); err != nil {
+		return InvalidError{msg: "wildcard has to be null or an object with the attribute exclude", field: fieldWildcard}
+	}
+
+	f.wildcard = true
+	if wildcard == nil {
+		return nil
+	}
+
+	f.exclude = make(map[string]bool, len(wildcard.Exclude))
+	for _, name := range wildcard.Exclude {
+		if !reField.MatchString(name) {
+			return InvalidError{msg: fmt.Sprintf("fieldname %q is not a valid fieldname", name), field: fieldWildcard}
+		}
+		f.exclude[name] = true
+	}
+	return nil
+}
+
+func (f *fieldsMap) keys(cid string, data map[dskey.Key]fieldDescription) error {
+	if f.wildcard {
+		collection, _, _ := strings.Cut(cid, "/")
+		for _, field := range restrict.FieldsForCollection(collection) {
+			if _, ok := f.fields[field]; ok || f.exclude[field] {
+				continue
+			}
+
+			key, err := buildGenericKey(cid, field)
+			if err != nil {
+				return err
+			}
+
+			// Template fields are requested like a template without values,
+			// so the keys of all replacements are build.
+			var description fieldDescription
+			if strings.Contains(field, "$") {
+				description = new(templateField)
+			}
+			addKey(data, key, description)
+		}
+	}
+
 	for field, description := range f.fields {
-		data[buildGenericKey(cid, field)] = description
+		key, err := buildGenericKey(cid, field)
+		if err != nil {
+			return err
+		}
+		addKey(data, key, description)
 	}
+	return nil
 }
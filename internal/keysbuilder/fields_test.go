@@ -286,6 +286,94 @@ func TestRequestErrors(t *testing.T) {
 			"field \"group_ids\": invalid collection name",
 			[]string{"group_ids"},
 		},
+		{
+			"Filter without value",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": {
+					"group_ids": {
+						"type": "relation-list",
+						"collection": "group",
+						"fields": {"name": null},
+						"filter": {"field": "name"}
+					}
+				}
+			}`,
+			`field "group_ids": filter needs one of equals or in`,
+			[]string{"group_ids"},
+		},
+		{
+			"Filter with invalid field",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": {
+					"group_ids": {
+						"type": "relation-list",
+						"collection": "group",
+						"fields": {"name": null},
+						"filter": {"field": "Name", "equals": "foo"}
+					}
+				}
+			}`,
+			`field "group_ids": filter field "Name" is not a valid fieldname`,
+			[]string{"group_ids"},
+		},
+		{
+			"Negative limit",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": {
+					"group_ids": {
+						"type": "relation-list",
+						"collection": "group",
+						"fields": {"name": null},
+						"limit": -1
+					}
+				}
+			}`,
+			`field "group_ids": limit can not be negative`,
+			[]string{"group_ids"},
+		},
+		{
+			"Order by template field",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": {
+					"group_ids": {
+						"type": "relation-list",
+						"collection": "group",
+						"fields": {"name": null},
+						"order_by": "name_$"
+					}
+				}
+			}`,
+			`field "group_ids": order_by "name_$" is not a valid fieldname`,
+			[]string{"group_ids"},
+		},
+		{
+			"Invalid wildcard",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": "all"
+			}`,
+			`fields has to be an object or "*"`,
+			nil,
+		},
+		{
+			"Invalid exclude",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": {"*": {"exclude": ["Name"]}}
+			}`,
+			`field "*": fieldname "Name" is not a valid fieldname`,
+			[]string{"*"},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			_, err := keysbuilder.FromJSON(strings.NewReader(tt.input))
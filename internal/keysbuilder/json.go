@@ -1,6 +1,7 @@
 package keysbuilder
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +11,16 @@ import (
 
 // FromJSON creates a Keysbuilder from json.
 func FromJSON(r io.Reader) (*Builder, error) {
-	var b body
-	if err := json.NewDecoder(r).Decode(&b); err != nil {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
 		if err == io.EOF {
 			return nil, InvalidError{msg: "No data"}
 		}
+		return nil, JSONError{err}
+	}
+
+	var b body
+	if err := json.Unmarshal(raw, &b); err != nil {
 		if sub, ok := err.(InvalidError); ok {
 			return nil, sub
 		}
@@ -22,21 +28,30 @@ func FromJSON(r io.Reader) (*Builder, error) {
 	}
 
 	kb := &Builder{
-		bodies: []body{b},
+		bodies:      []body{b},
+		fingerprint: normalizeJSON(raw),
 	}
 	return kb, nil
 }
 
 // ManyFromJSON creates a list of Keysbuilder objects from a json list.
 func ManyFromJSON(r io.Reader) (*Builder, error) {
-	var bs []body
-	if err := json.NewDecoder(r).Decode(&bs); err != nil {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
 		if err == io.EOF {
-			return &Builder{}, nil
+			return &Builder{fingerprint: "[]"}, nil
 		}
 		if errors.Is(err, io.ErrUnexpectedEOF) {
 			return nil, InvalidError{msg: "Body is not complete"}
 		}
+		if jerr, ok := err.(*json.SyntaxError); ok {
+			return nil, JSONError{jerr}
+		}
+		return nil, fmt.Errorf("decode keysrequest: %w", err)
+	}
+
+	var bs []body
+	if err := json.Unmarshal(raw, &bs); err != nil {
 		if sub, ok := err.(InvalidError); ok {
 			return nil, sub
 		}
@@ -66,7 +81,27 @@ func ManyFromJSON(r io.Reader) (*Builder, error) {
 	}
 
 	kb := &Builder{
-		bodies: bs,
+		bodies:      bs,
+		fingerprint: normalizeJSON(raw),
 	}
 	return kb, nil
 }
+
+// normalizeJSON returns the json without whitespace and with sorted object
+// keys. Two requests that only differ in the formatting get the same value.
+func normalizeJSON(raw json.RawMessage) string {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		// raw was already decoded, so this can not happen.
+		return ""
+	}
+
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(normalized)
+}
@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 )
@@ -24,12 +28,17 @@ type Builder struct {
 
 	bodies []body
 	keys   []dskey.Key
+	limits Limits
+
+	// fingerprint is the normalized request, that created the builder.
+	fingerprint string
 }
 
 // FromKeys creates a keysbuilder from a list of keys.
 func FromKeys(rawKeys ...string) (*Builder, error) {
 	b := new(Builder)
 	if len(rawKeys) == 0 || rawKeys[0] == "" {
+		b.fingerprint = "k:"
 		return b, nil
 	}
 
@@ -55,23 +64,62 @@ func FromKeys(rawKeys ...string) (*Builder, error) {
 		}
 		b.bodies = append(b.bodies, body)
 	}
+
+	sorted := append([]string{}, rawKeys...)
+	sort.Strings(sorted)
+	b.fingerprint = "k:" + strings.Join(sorted, ",")
 	return b, nil
 }
 
 // FromBuilders creates a new keysbuilder from a list of other builders.
 func FromBuilders(builders ...*Builder) *Builder {
 	builder := new(Builder)
+	fingerprints := make([]string, 0, len(builders))
+	withFingerprint := true
 	for _, b := range builders {
 		builder.bodies = append(builder.bodies, b.bodies...)
+
+		if b.fingerprint == "" {
+			withFingerprint = false
+			continue
+		}
+		fingerprints = append(fingerprints, b.fingerprint)
+	}
+
+	if withFingerprint {
+		builder.fingerprint = strings.Join(fingerprints, "\n")
 	}
 	return builder
 }
 
+// SetLimits sets the limits for the complexity of the request. Update returns a
+// LimitError, if one of them is exceeded.
+func (b *Builder) SetLimits(limits Limits) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limits = limits
+}
+
+// Fingerprint returns the normalized request, that created the builder. Two
+// builders with the same fingerprint create the same keys.
+//
+// Returns an empty string, if the builder was not created from a request.
+func (b *Builder) Fingerprint() string {
+	return b.fingerprint
+}
+
 // Update triggers a key update. It generates the list of keys, that can be
 // requested with the Keys() method. It travels the KeysRequests object like a
 // tree.
 //
+// Filters and the order of relation lists are evaluated with the current
+// data. So the keys can change, when the filtered or sorted field changes.
+//
 // It is not allowed to call builder.Keys() after Update returned an error.
+//
+// If the context has the tag profile_restrict, the cost of the update is
+// logged.
 func (b *Builder) Update(ctx context.Context, getter datastore.Getter) (err error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -87,10 +135,31 @@ func (b *Builder) Update(ctx context.Context, getter datastore.Getter) (err erro
 		return nil
 	}
 
+	if err := b.limits.checkDepth(b.bodies); err != nil {
+		return err
+	}
+
+	var c cost
+	start := time.Now()
+	if oserror.HasTagFromContext(ctx, "profile_restrict") {
+		defer func() {
+			c.keys = len(b.keys)
+			c.duration = time.Since(start)
+
+			request := b.fingerprint
+			if request == "" {
+				request = "unknown request"
+			}
+			c.log(request)
+		}()
+	}
+
 	// Start with all keys from all the bodies.
 	process := make(map[dskey.Key]fieldDescription)
 	for _, body := range b.bodies {
-		body.keys(process)
+		if err := body.keys(process); err != nil {
+			return err
+		}
 	}
 
 	b.keys = b.keys[:0]
@@ -99,7 +168,9 @@ func (b *Builder) Update(ctx context.Context, getter datastore.Getter) (err erro
 	for {
 		// Get all keys and descriptions
 		for key, description := range process {
-			b.keys = append(b.keys, key)
+			if !hiddenField(description) {
+				b.keys = append(b.keys, key)
+			}
 			if description == nil {
 				continue
 			}
@@ -108,10 +179,20 @@ func (b *Builder) Update(ctx context.Context, getter datastore.Getter) (err erro
 			processed[key] = description
 		}
 
+		if err := b.limits.checkKeys(len(b.keys)); err != nil {
+			return err
+		}
+
 		if len(needed) == 0 {
 			break
 		}
 
+		c.iterations++
+		c.fetched += len(needed)
+		if err := b.limits.checkIterations(c.iterations); err != nil {
+			return err
+		}
+
 		// Get values for all special (not none) fields.
 		data, err := getter.Get(ctx, needed...)
 		if err != nil {
@@ -141,6 +222,14 @@ func (b *Builder) Update(ctx context.Context, getter datastore.Getter) (err erro
 			}
 		}
 
+		// Lists with a filter or an order can only add the keys of their
+		// objects, after the values of all objects are known.
+		for _, selection := range listSelections(processed) {
+			if err := selection.keys(process); err != nil {
+				return err
+			}
+		}
+
 		// Clear processed.
 		for k := range processed {
 			delete(processed, k)
@@ -163,11 +252,16 @@ func (b *Builder) Keys() []dskey.Key {
 // together.
 //
 // buildGenericKey("motion/5", "title") -> "motion/5/title".
-func buildGenericKey(collectionID string, field string) dskey.Key {
+//
+// Returns an error, if the key is invalid. This can happen, if the
+// collectionID is the value of a generic relation from the datastore.
+func buildGenericKey(collectionID string, field string) (dskey.Key, error) {
 	key, err := dskey.FromString(collectionID + "/" + field)
-	_ = err // TODO: Can this happen?
+	if err != nil {
+		return dskey.Key{}, fmt.Errorf("building key for %s: %w", collectionID, err)
+	}
 
-	return key
+	return key, nil
 }
 
 func buildCollectionID(collection string, id int) string {
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
 )
@@ -257,6 +258,204 @@ func TestKeys(t *testing.T) {
 			`user/1/likes: ["other/1","other/2"]`,
 			keys("user/1/likes", "other/1/name", "other/2/name"),
 		},
+		{
+			"List field with filter equals",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"fields": {"title": null},
+						"filter": {"field": "state_id", "equals": 5}
+					}
+				}
+			}`,
+			`---
+			meeting/1/motion_ids: [1,2,3]
+			motion/1/state_id: 5
+			motion/2/state_id: 6
+			`,
+			keys("meeting/1/motion_ids", "motion/1/title"),
+		},
+		{
+			"List field with filter in",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"fields": {"title": null},
+						"filter": {"field": "state_id", "in": [5, 6]}
+					}
+				}
+			}`,
+			`---
+			meeting/1/motion_ids: [1,2,3]
+			motion/1/state_id: 5
+			motion/2/state_id: 6
+			motion/3/state_id: 7
+			`,
+			keys("meeting/1/motion_ids", "motion/1/title", "motion/2/title"),
+		},
+		{
+			"List field with filter on requested field",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"fields": {"title": null, "state_id": null},
+						"filter": {"field": "state_id", "equals": 5}
+					}
+				}
+			}`,
+			`---
+			meeting/1/motion_ids: [1,2]
+			motion/1/state_id: 5
+			motion/2/state_id: 6
+			`,
+			keys("meeting/1/motion_ids", "motion/1/title", "motion/1/state_id"),
+		},
+		{
+			"Generic list field with filter",
+			`{
+				"ids": [1],
+				"collection": "user",
+				"fields": {
+					"likes": {
+						"type": "generic-relation-list",
+						"fields": {"name": null},
+						"filter": {"field": "published", "equals": true}
+					}
+				}
+			}`,
+			`---
+			user/1/likes: ["other/1","other/2"]
+			other/1/published: true
+			other/2/published: false
+			`,
+			keys("user/1/likes", "other/1/name"),
+		},
+		{
+			"List field with limit and offset",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"user_ids": {
+						"type": "relation-list",
+						"collection": "user",
+						"fields": {"name": null},
+						"offset": 1,
+						"limit": 2
+					}
+				}
+			}`,
+			`meeting/1/user_ids: [4,3,2,1]`,
+			keys("meeting/1/user_ids", "user/3/name", "user/2/name"),
+		},
+		{
+			"List field with order",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"user_ids": {
+						"type": "relation-list",
+						"collection": "user",
+						"fields": {"name": null},
+						"order_by": "name",
+						"limit": 2
+					}
+				}
+			}`,
+			`---
+			meeting/1/user_ids: [1,2,3,4]
+			user/1/name: dave
+			user/2/name: bob
+			user/3/name: carol
+			`,
+			keys("meeting/1/user_ids", "user/2/name", "user/3/name"),
+		},
+		{
+			"List field with order descending",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"user_ids": {
+						"type": "relation-list",
+						"collection": "user",
+						"fields": {"name": null},
+						"order_by": "weight",
+						"descending": true,
+						"limit": 2
+					}
+				}
+			}`,
+			`---
+			meeting/1/user_ids: [1,2,3,4]
+			user/1/weight: 10
+			user/2/weight: 2
+			user/3/weight: 30
+			`,
+			keys("meeting/1/user_ids", "user/1/name", "user/3/name"),
+		},
+		{
+			"List field with order and missing values",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"user_ids": {
+						"type": "relation-list",
+						"collection": "user",
+						"fields": {"name": null},
+						"order_by": "weight",
+						"offset": 2
+					}
+				}
+			}`,
+			`---
+			meeting/1/user_ids: [1,2,3,4]
+			user/2/weight: 2
+			user/3/weight: 30
+			`,
+			keys("meeting/1/user_ids", "user/1/name", "user/4/name"),
+		},
+		{
+			"List field with filter and order",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"fields": {"title": null},
+						"filter": {"field": "state_id", "equals": 5},
+						"order_by": "number",
+						"limit": 1
+					}
+				}
+			}`,
+			`---
+			meeting/1/motion_ids: [1,2,3]
+			motion/1/state_id: 5
+			motion/1/number: 3
+			motion/2/state_id: 6
+			motion/2/number: 1
+			motion/3/state_id: 5
+			motion/3/number: 2
+			`,
+			keys("meeting/1/motion_ids", "motion/3/title"),
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := dsmock.Stub(dsmock.YAMLData(tt.data))
@@ -439,6 +638,61 @@ func TestUpdate(t *testing.T) {
 			keys("user/1/group_ids", "group/2/perm_ids", "perm/2/name", "perm/1/name"),
 			1,
 		},
+		{
+			"Filter value changes",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"fields": {"title": null},
+						"filter": {"field": "state_id", "equals": 5}
+					}
+				}
+			}`,
+			`---
+			meeting/1/motion_ids: [1,2]
+			motion/1/state_id: 5
+			motion/2/state_id: 6
+			`,
+			`---
+			meeting/1/motion_ids: [1,2]
+			motion/1/state_id: 6
+			motion/2/state_id: 5
+			`,
+			keys("meeting/1/motion_ids", "motion/2/title"),
+			1,
+		},
+		{
+			"Page content shifts",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"user_ids": {
+						"type": "relation-list",
+						"collection": "user",
+						"fields": {"name": null},
+						"order_by": "weight",
+						"limit": 1
+					}
+				}
+			}`,
+			`---
+			meeting/1/user_ids: [1,2]
+			user/1/weight: 1
+			user/2/weight: 2
+			`,
+			`---
+			meeting/1/user_ids: [1,2]
+			user/1/weight: 3
+			user/2/weight: 2
+			`,
+			keys("meeting/1/user_ids", "user/2/name"),
+			1,
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := dsmock.Stub(dsmock.YAMLData(tt.data))
@@ -593,6 +847,32 @@ func TestError(t *testing.T) {
 	}
 }
 
+func TestInvalidGenericRelation(t *testing.T) {
+	ds := dsmock.Stub(dsmock.YAMLData(`
+	agenda_item/1/content_object_id: topic
+	`))
+	json := `
+	{
+		"ids": [1],
+		"collection": "agenda_item",
+		"fields": {
+			"content_object_id": {
+				"type": "generic-relation",
+				"fields": {"title": null}
+			}
+		}
+	}`
+
+	b, err := keysbuilder.FromJSON(strings.NewReader(json))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	if err := b.Update(context.Background(), ds); err == nil {
+		t.Fatalf("Expected Update() to return an error, got none")
+	}
+}
+
 func TestRequestCount(t *testing.T) {
 	ds, _ := dsmock.NewMockDatastore(nil)
 	json := `{
@@ -631,3 +911,256 @@ func TestRequestCount(t *testing.T) {
 		t.Errorf("Updated() did %d requests, expected 1", got)
 	}
 }
+
+func TestWildcard(t *testing.T) {
+	topicKeys := func(exclude ...string) []dskey.Key {
+		excluded := make(map[string]bool)
+		for _, field := range exclude {
+			excluded[field] = true
+		}
+
+		var keys []dskey.Key
+		for _, field := range restrict.FieldsForCollection("topic") {
+			if !excluded[field] {
+				keys = append(keys, dskey.Key{Collection: "topic", ID: 1, Field: field})
+			}
+		}
+		return keys
+	}
+
+	for _, tt := range []struct {
+		name    string
+		request string
+		data    string
+		keys    []dskey.Key
+	}{
+		{
+			"String",
+			`{
+				"ids": [1],
+				"collection": "topic",
+				"fields": "*"
+			}`,
+			"",
+			topicKeys(),
+		},
+		{
+			"Object",
+			`{
+				"ids": [1],
+				"collection": "topic",
+				"fields": {"*": null}
+			}`,
+			"",
+			topicKeys(),
+		},
+		{
+			"Exclude",
+			`{
+				"ids": [1],
+				"collection": "topic",
+				"fields": {"*": {"exclude": ["text", "poll_ids"]}}
+			}`,
+			"",
+			topicKeys("text", "poll_ids"),
+		},
+		{
+			"With relation",
+			`{
+				"ids": [1],
+				"collection": "topic",
+				"fields": {
+					"*": null,
+					"tag_ids": {
+						"type": "relation-list",
+						"collection": "tag",
+						"fields": {"name": null}
+					}
+				}
+			}`,
+			"topic/1/tag_ids: [3]",
+			append(topicKeys(), dskey.MustKey("tag/3/name")),
+		},
+		{
+			"Generic relation",
+			`{
+				"ids": [1],
+				"collection": "agenda_item",
+				"fields": {
+					"content_object_id": {
+						"type": "generic-relation",
+						"fields": "*"
+					}
+				}
+			}`,
+			"agenda_item/1/content_object_id: topic/1",
+			append(topicKeys(), dskey.MustKey("agenda_item/1/content_object_id")),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := dsmock.Stub(dsmock.YAMLData(tt.data))
+			b, err := keysbuilder.FromJSON(strings.NewReader(tt.request))
+			if err != nil {
+				t.Fatalf("FromJSON returned the unexpected error: %v", err)
+			}
+
+			if err := b.Update(context.Background(), ds); err != nil {
+				t.Fatalf("Building keys: %v", err)
+			}
+
+			if diff := cmpSet(set(tt.keys...), set(b.Keys()...)); diff != nil {
+				t.Errorf("Got keys %v, expected %v", diff, tt.keys)
+			}
+		})
+	}
+}
+
+func TestWildcardTemplate(t *testing.T) {
+	ds := dsmock.Stub(dsmock.YAMLData(`
+	user/1/group_$_ids: ["5"]
+	`))
+	request := `{
+		"ids": [1],
+		"collection": "user",
+		"fields": "*"
+	}`
+
+	b, err := keysbuilder.FromJSON(strings.NewReader(request))
+	if err != nil {
+		t.Fatalf("FromJSON returned the unexpected error: %v", err)
+	}
+
+	if err := b.Update(context.Background(), ds); err != nil {
+		t.Fatalf("Building keys: %v", err)
+	}
+
+	got := set(b.Keys()...)
+	for _, key := range []string{"user/1/username", "user/1/group_$_ids", "user/1/group_$5_ids"} {
+		if !got[dskey.MustKey(key)] {
+			t.Errorf("Key %s is missing", key)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	request := `{
+		"ids": [1],
+		"collection": "meeting",
+		"fields": {
+			"user_ids": {
+				"type": "relation-list",
+				"collection": "user",
+				"fields": {
+					"meeting_ids": {
+						"type": "relation-list",
+						"collection": "meeting",
+						"fields": {"name": null}
+					}
+				}
+			}
+		}
+	}`
+
+	data := `---
+	meeting/1/user_ids: [1,2]
+	user/1/meeting_ids: [1]
+	user/2/meeting_ids: [1,2]
+	`
+
+	for _, tt := range []struct {
+		name   string
+		limits keysbuilder.Limits
+		limit  string
+	}{
+		{"no limits", keysbuilder.Limits{}, ""},
+		{"within limits", keysbuilder.Limits{MaxDepth: 3, MaxKeys: 5, MaxIterations: 2}, ""},
+		{"depth", keysbuilder.Limits{MaxDepth: 2}, "depth"},
+		{"keys", keysbuilder.Limits{MaxKeys: 4}, "keys"},
+		{"iterations", keysbuilder.Limits{MaxIterations: 1}, "iterations"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := keysbuilder.FromJSON(strings.NewReader(request))
+			if err != nil {
+				t.Fatalf("FromJSON: %v", err)
+			}
+			b.SetLimits(tt.limits)
+
+			err = b.Update(context.Background(), dsmock.Stub(dsmock.YAMLData(data)))
+
+			if tt.limit == "" {
+				if err != nil {
+					t.Fatalf("Update: %v", err)
+				}
+				return
+			}
+
+			var errLimit keysbuilder.LimitError
+			if !errors.As(err, &errLimit) {
+				t.Fatalf("Update returned error `%v`, expected a LimitError", err)
+			}
+
+			if errLimit.Limit() != tt.limit {
+				t.Errorf("got limit %s, expected %s", errLimit.Limit(), tt.limit)
+			}
+
+			if len(b.Keys()) != 0 {
+				t.Errorf("got keys %v after an error, expected none", b.Keys())
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(request string) string {
+		kb, err := keysbuilder.ManyFromJSON(strings.NewReader(request))
+		if err != nil {
+			t.Fatalf("ManyFromJSON: %v", err)
+		}
+		return kb.Fingerprint()
+	}
+
+	a := fingerprint(`[{"ids":[1],"collection":"user","fields":{"name":null,"email":null}}]`)
+	b := fingerprint(`[
+		{
+			"collection": "user",
+			"ids": [1],
+			"fields": {"email": null, "name": null}
+		}
+	]`)
+	c := fingerprint(`[{"ids":[2],"collection":"user","fields":{"name":null,"email":null}}]`)
+
+	if a != b {
+		t.Errorf("fingerprints of the same request with different formatting are different: %q and %q", a, b)
+	}
+
+	if a == c {
+		t.Errorf("fingerprints of different requests are the same: %q", a)
+	}
+
+	k1, _ := keysbuilder.FromKeys("user/1/name", "user/1/email")
+	k2, _ := keysbuilder.FromKeys("user/1/email", "user/1/name")
+	if k1.Fingerprint() != k2.Fingerprint() {
+		t.Errorf("fingerprints of the same keys in different order are different: %q and %q", k1.Fingerprint(), k2.Fingerprint())
+	}
+}
+
+func TestFromBuildersWithoutFingerprint(t *testing.T) {
+	first, _ := keysbuilder.FromKeys("user/1/name")
+	last, _ := keysbuilder.FromKeys("user/1/email")
+
+	// A builder, that was not created from a request, has no fingerprint.
+	b := keysbuilder.FromBuilders(first, new(keysbuilder.Builder), last)
+
+	if b.Fingerprint() != "" {
+		t.Errorf("got fingerprint %q, expected none", b.Fingerprint())
+	}
+
+	if err := b.Update(context.Background(), dsmock.Stub(nil)); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	expect := keys("user/1/name", "user/1/email")
+	if diff := cmpSet(set(expect...), set(b.Keys()...)); diff != nil {
+		t.Errorf("Got keys %v, expected %v", diff, expect)
+	}
+}
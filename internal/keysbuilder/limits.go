@@ -0,0 +1,108 @@
+package keysbuilder
+
+import (
+	"log"
+	"time"
+)
+
+// Limits are the limits for the complexity of a keys request. A value of 0
+// means no limit.
+type Limits struct {
+	// MaxDepth is the maximum nesting of relations in the request.
+	MaxDepth int
+
+	// MaxKeys is the maximum number of keys, that a request can build.
+	MaxKeys int
+
+	// MaxIterations is the maximum number of times, the keysbuilder fetches
+	// values to follow relations. Filters and the order of lists need an
+	// additional iteration.
+	MaxIterations int
+}
+
+// checkKeys returns an error, if the number of keys is too high.
+func (l Limits) checkKeys(count int) error {
+	if l.MaxKeys > 0 && count > l.MaxKeys {
+		return LimitError{limit: "keys", max: l.MaxKeys}
+	}
+	return nil
+}
+
+// checkIterations returns an error, if the number of iterations is too high.
+func (l Limits) checkIterations(count int) error {
+	if l.MaxIterations > 0 && count > l.MaxIterations {
+		return LimitError{limit: "iterations", max: l.MaxIterations}
+	}
+	return nil
+}
+
+// checkDepth returns an error, if one of the bodies is too deep.
+func (l Limits) checkDepth(bodies []body) error {
+	if l.MaxDepth == 0 {
+		return nil
+	}
+
+	for _, b := range bodies {
+		if fieldsDepth(&b.fieldsMap) > l.MaxDepth {
+			return LimitError{limit: "depth", max: l.MaxDepth}
+		}
+	}
+	return nil
+}
+
+// fieldsDepth returns the nesting of the fields. Fields without relations have
+// the depth 1.
+func fieldsDepth(f *fieldsMap) int {
+	depth := 1
+	for _, description := range f.fields {
+		if d := 1 + descriptionDepth(description); d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// descriptionDepth returns the nesting of a field description. A field
+// without a relation has the depth 0.
+func descriptionDepth(description fieldDescription) int {
+	switch d := description.(type) {
+	case *relationField:
+		return fieldsDepth(&d.fieldsMap)
+	case *relationListField:
+		return fieldsDepth(&d.fieldsMap)
+	case *genericRelationField:
+		return fieldsDepth(&d.fieldsMap)
+	case *genericRelationListField:
+		return fieldsDepth(&d.fieldsMap)
+	case *templateField:
+		return 1 + descriptionDepth(d.values)
+	default:
+		return 0
+	}
+}
+
+// cost is the work of one call to Builder.Update.
+type cost struct {
+	keys       int
+	fetched    int
+	iterations int
+	duration   time.Duration
+}
+
+// value returns the cost as one number. Each key has to be restricted and
+// sent to the client. Each fetched key is read before the data is read.
+func (c cost) value() int {
+	return c.keys + c.fetched
+}
+
+func (c cost) log(request string) {
+	log.Printf(
+		"Profile: Keysbuilder:\nProfile: Request: %s\nProfile: Cost: %d (%d keys, %d fetched keys, %d iterations) in %d ms\n",
+		request,
+		c.value(),
+		c.keys,
+		c.fetched,
+		c.iterations,
+		c.duration.Milliseconds(),
+	)
+}
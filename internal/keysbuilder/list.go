@@ -0,0 +1,351 @@
+package keysbuilder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+)
+
+// listOptions are the options of a relation-list or a generic-relation-list to
+// select only some of the related objects.
+//
+//	{
+//		"filter": {"field": "state_id", "in": [5, 6]},
+//		"order_by": "weight",
+//		"descending": false,
+//		"offset": 20,
+//		"limit": 10
+//	}
+//
+// The objects are filtered, then sorted by the field order_by and then the
+// offset and the limit are applied. Without order_by, the objects keep the
+// order of the list.
+type listOptions struct {
+	filter     *listFilter
+	orderBy    string
+	descending bool
+	offset     int
+	limit      int
+}
+
+func (o *listOptions) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Filter     *listFilter `json:"filter"`
+		OrderBy    string      `json:"order_by"`
+		Descending bool        `json:"descending"`
+		Offset     int         `json:"offset"`
+		Limit      int         `json:"limit"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+
+	if options.OrderBy != "" && !validListField(options.OrderBy) {
+		return InvalidError{msg: fmt.Sprintf("order_by %q is not a valid fieldname", options.OrderBy)}
+	}
+
+	if options.Offset < 0 {
+		return InvalidError{msg: "offset can not be negative"}
+	}
+
+	if options.Limit < 0 {
+		return InvalidError{msg: "limit can not be negative"}
+	}
+
+	o.filter = options.Filter
+	o.orderBy = options.OrderBy
+	o.descending = options.Descending
+	o.offset = options.Offset
+	o.limit = options.Limit
+	return nil
+}
+
+// needsValues returns true, if the values of the objects are needed to select
+// them.
+func (o *listOptions) needsValues() bool {
+	return o.filter != nil || o.orderBy != ""
+}
+
+// keys adds the keys of the selected objects.
+//
+// If the options need values of the objects, only the keys for these values
+// are added. The keys of the selected objects are added by the listSelection
+// after the values are fetched.
+func (o *listOptions) keys(cids []string, fields *fieldsMap, data map[dskey.Key]fieldDescription) error {
+	if !o.needsValues() {
+		for _, cid := range o.page(cids) {
+			if err := fields.keys(cid, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	selection := &listSelection{
+		options: o,
+		cids:    cids,
+		fields:  fields,
+		values:  make(map[string]map[string]json.RawMessage, len(cids)),
+	}
+
+	var valueFields []string
+	if o.filter != nil {
+		valueFields = append(valueFields, o.filter.field)
+	}
+	if o.orderBy != "" && (o.filter == nil || o.filter.field != o.orderBy) {
+		valueFields = append(valueFields, o.orderBy)
+	}
+
+	for _, cid := range cids {
+		for _, field := range valueFields {
+			key, err := buildGenericKey(cid, field)
+			if err != nil {
+				return err
+			}
+			addKey(data, key, &listItem{selection: selection, cid: cid, field: field})
+		}
+	}
+	return nil
+}
+
+// page applies the offset and the limit.
+func (o *listOptions) page(cids []string) []string {
+	if o.offset >= len(cids) {
+		return nil
+	}
+	cids = cids[o.offset:]
+
+	if o.limit > 0 && o.limit < len(cids) {
+		cids = cids[:o.limit]
+	}
+	return cids
+}
+
+// listFilter selects the objects of a relation-list or a
+// generic-relation-list by the value of one of their fields.
+//
+//	{"field": "state_id", "equals": 5}
+//	{"field": "state_id", "in": [5, 6]}
+//
+// Only the keys of objects, where the field has the value (equals) or one of
+// the values (in), are build. Objects, where the field does not exist or the
+// user can not see it, do not match.
+type listFilter struct {
+	field string
+
+	// values are the normalized json values, that match.
+	values map[string]bool
+}
+
+func (f *listFilter) UnmarshalJSON(data []byte) error {
+	var filter struct {
+		Field  string            `json:"field"`
+		Equals json.RawMessage   `json:"equals"`
+		In     []json.RawMessage `json:"in"`
+	}
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return err
+	}
+
+	if filter.Field == "" {
+		return InvalidError{msg: "filter has no field"}
+	}
+	if !validListField(filter.Field) {
+		return InvalidError{msg: fmt.Sprintf("filter field %q is not a valid fieldname", filter.Field)}
+	}
+
+	if (filter.Equals == nil) == (filter.In == nil) {
+		return InvalidError{msg: "filter needs one of equals or in"}
+	}
+
+	values := filter.In
+	if filter.Equals != nil {
+		values = []json.RawMessage{filter.Equals}
+	}
+
+	f.field = filter.Field
+	f.values = make(map[string]bool, len(values))
+	for _, value := range values {
+		f.values[normalizeJSON(value)] = true
+	}
+	return nil
+}
+
+// match returns true, if the value matches the filter.
+func (f *listFilter) match(value json.RawMessage) bool {
+	if value == nil {
+		return false
+	}
+	return f.values[normalizeJSON(value)]
+}
+
+// validListField returns true, if the field can be used to filter or to sort
+// a list. Template fields can not be used.
+func validListField(field string) bool {
+	return reField.MatchString(field) && !strings.Contains(field, "$")
+}
+
+// listSelection selects the objects of one list, after the values of the
+// objects are fetched.
+type listSelection struct {
+	options *listOptions
+	cids    []string
+	fields  *fieldsMap
+
+	// values are the values of the objects from the cid to the field. Values,
+	// that do not exist, are missing.
+	values map[string]map[string]json.RawMessage
+}
+
+// keys adds the keys of the selected objects.
+func (s *listSelection) keys(data map[dskey.Key]fieldDescription) error {
+	var selected []string
+	for _, cid := range s.cids {
+		if s.options.filter == nil || s.options.filter.match(s.values[cid][s.options.filter.field]) {
+			selected = append(selected, cid)
+		}
+	}
+
+	if field := s.options.orderBy; field != "" {
+		sort.SliceStable(selected, func(i, j int) bool {
+			a := s.values[selected[i]][field]
+			b := s.values[selected[j]][field]
+
+			// Objects without the value are always at the end.
+			if a == nil || b == nil {
+				return a != nil && b == nil
+			}
+
+			if s.options.descending {
+				return compareJSON(b, a) < 0
+			}
+			return compareJSON(a, b) < 0
+		})
+	}
+
+	for _, cid := range s.options.page(selected) {
+		if err := s.fields.keys(cid, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listItem is the description of a key, that is needed by a listSelection.
+//
+// The key is only needed to build the other keys. It is not returned by
+// Builder.Keys().
+type listItem struct {
+	selection *listSelection
+	cid       string
+	field     string
+}
+
+func (l *listItem) keys(key dskey.Key, value json.RawMessage, data map[dskey.Key]fieldDescription) error {
+	values := l.selection.values[l.cid]
+	if values == nil {
+		values = make(map[string]json.RawMessage)
+		l.selection.values[l.cid] = values
+	}
+	values[l.field] = value
+	return nil
+}
+
+// listSelections returns the selections of the descriptions. Each selection
+// is returned only once.
+func listSelections(descriptions map[dskey.Key]fieldDescription) []*listSelection {
+	seen := make(map[*listSelection]bool)
+	var selections []*listSelection
+
+	var add func(description fieldDescription)
+	add = func(description fieldDescription) {
+		switch d := description.(type) {
+		case *listItem:
+			if !seen[d.selection] {
+				seen[d.selection] = true
+				selections = append(selections, d.selection)
+			}
+		case multiField:
+			for _, sub := range d {
+				add(sub)
+			}
+		}
+	}
+
+	for _, description := range descriptions {
+		add(description)
+	}
+	return selections
+}
+
+// compareJSON compares two json values. It returns a negative number, if a is
+// less then b, 0 if they are equal and a positive number otherwise.
+//
+// Numbers are compared by there value and strings alphabetically. Values of
+// different types are sorted by the type: null, false, true, numbers, strings
+// and then all other values.
+func compareJSON(a, b json.RawMessage) int {
+	rankA, rankB := jsonRank(a), jsonRank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch rankA {
+	case rankNumber:
+		numA, _ := strconv.ParseFloat(string(bytes.TrimSpace(a)), 64)
+		numB, _ := strconv.ParseFloat(string(bytes.TrimSpace(b)), 64)
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		}
+		return 0
+
+	case rankString:
+		var strA, strB string
+		_ = json.Unmarshal(a, &strA)
+		_ = json.Unmarshal(b, &strB)
+		return strings.Compare(strA, strB)
+
+	case rankOther:
+		return strings.Compare(normalizeJSON(a), normalizeJSON(b))
+	}
+	return 0
+}
+
+const (
+	rankNull = iota
+	rankFalse
+	rankTrue
+	rankNumber
+	rankString
+	rankOther
+)
+
+func jsonRank(value json.RawMessage) int {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return rankNull
+	}
+
+	switch value[0] {
+	case 'n':
+		return rankNull
+	case 'f':
+		return rankFalse
+	case 't':
+		return rankTrue
+	case '"':
+		return rankString
+	case '[', '{':
+		return rankOther
+	default:
+		return rankNumber
+	}
+}
@@ -0,0 +1,191 @@
+package keysbuilder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var rePresetParam = regexp.MustCompile(`^\$([a-z][a-z0-9_]*)$`)
+
+// Presets is a registry of named keys requests.
+//
+// Each preset is a keys request like in ManyFromJSON. It can have parameters.
+// A parameter is a string value that starts with $. For example:
+//
+//	[{"ids": ["$id"], "collection": "motion", "fields": {"title": null}}]
+//
+// The parameter $id is replaced with the value given to Builder.
+type Presets struct {
+	presets map[string]preset
+}
+
+type preset struct {
+	request any
+	params  []string
+}
+
+// LoadPresets reads all presets from a directory.
+//
+// Each file with the extension .yml, .yaml or .json is a preset. The name of
+// the preset is the filename without the extension. All presets are validated
+// when they are loaded.
+func LoadPresets(dir string) (*Presets, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading preset dir: %w", err)
+	}
+
+	presets := Presets{presets: make(map[string]preset)}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yml" && ext != ".yaml" && ext != ".json") {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if _, ok := presets.presets[name]; ok {
+			return nil, fmt.Errorf("preset %s is defined more then once", name)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading preset %s: %w", name, err)
+		}
+
+		p, err := parsePreset(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing preset %s: %w", name, err)
+		}
+		presets.presets[name] = p
+	}
+
+	return &presets, nil
+}
+
+// parsePreset parses and validates one preset. The content can be yaml or json.
+func parsePreset(content []byte) (preset, error) {
+	var request any
+	if err := yaml.Unmarshal(content, &request); err != nil {
+		return preset{}, fmt.Errorf("decoding: %w", err)
+	}
+
+	if _, ok := request.([]any); !ok {
+		request = []any{request}
+	}
+
+	paramSet := make(map[string]bool)
+	replacePresetParams(copyPresetValue(request), func(name string) any {
+		paramSet[name] = true
+		return 1
+	})
+
+	params := make([]string, 0, len(paramSet))
+	for name := range paramSet {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+
+	p := preset{request: request, params: params}
+
+	// Validate the preset with a value for each parameter.
+	testParams := make(map[string]string, len(params))
+	for _, name := range params {
+		testParams[name] = "1"
+	}
+	if _, err := p.builder(testParams); err != nil {
+		return preset{}, fmt.Errorf("invalid keys request: %w", err)
+	}
+
+	return p, nil
+}
+
+// Builder creates a keysbuilder from the preset with the given name.
+//
+// params has to contain a value for each parameter of the preset. Values, that
+// are integers, are used as numbers. All other values are used as strings.
+func (p *Presets) Builder(name string, params map[string]string) (*Builder, error) {
+	pr, ok := p.presets[name]
+	if !ok {
+		return nil, InvalidError{msg: fmt.Sprintf("unknown preset %s", name)}
+	}
+
+	for _, param := range pr.params {
+		if _, ok := params[param]; !ok {
+			return nil, InvalidError{msg: fmt.Sprintf("preset %s needs the parameter %s", name, param)}
+		}
+	}
+
+	return pr.builder(params)
+}
+
+func (p preset) builder(params map[string]string) (*Builder, error) {
+	request := replacePresetParams(copyPresetValue(p.request), func(name string) any {
+		value := params[name]
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+		return value
+	})
+
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("encoding preset: %w", err)
+	}
+
+	return ManyFromJSON(bytes.NewReader(encoded))
+}
+
+// replacePresetParams replaces all parameters in the value with the return
+// value of the function replace. It returns the new value.
+//
+// Lists and maps are changed in place.
+func replacePresetParams(value any, replace func(name string) any) any {
+	switch v := value.(type) {
+	case string:
+		if match := rePresetParam.FindStringSubmatch(v); match != nil {
+			return replace(match[1])
+		}
+
+	case []any:
+		for i := range v {
+			v[i] = replacePresetParams(v[i], replace)
+		}
+
+	case map[string]any:
+		for key := range v {
+			v[key] = replacePresetParams(v[key], replace)
+		}
+	}
+	return value
+}
+
+// copyPresetValue returns a deep copy of a decoded preset.
+func copyPresetValue(value any) any {
+	switch v := value.(type) {
+	case []any:
+		c := make([]any, len(v))
+		for i := range v {
+			c[i] = copyPresetValue(v[i])
+		}
+		return c
+
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key := range v {
+			c[key] = copyPresetValue(v[key])
+		}
+		return c
+
+	default:
+		return value
+	}
+}
@@ -0,0 +1,143 @@
+package keysbuilder_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
+)
+
+func writePresets(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("writing preset %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestPresets(t *testing.T) {
+	dir := writePresets(t, map[string]string{
+		"motion_detail.yml": "" +
+			"- ids: [$id]\n" +
+			"  collection: motion\n" +
+			"  fields:\n" +
+			"    title: null\n" +
+			"    submitter_ids:\n" +
+			"      type: relation-list\n" +
+			"      collection: motion_submitter\n" +
+			"      fields:\n" +
+			"        weight: null\n",
+		"user.json": `{"ids": ["$id"], "collection": "user", "fields": {"username": null}}`,
+		"README.md": `not a preset`,
+	})
+
+	presets, err := keysbuilder.LoadPresets(dir)
+	if err != nil {
+		t.Fatalf("LoadPresets: %v", err)
+	}
+
+	t.Run("yaml", func(t *testing.T) {
+		b, err := presets.Builder("motion_detail", map[string]string{"id": "5"})
+		if err != nil {
+			t.Fatalf("Builder: %v", err)
+		}
+
+		ds := dsmock.Stub(dsmock.YAMLData(`motion/5/submitter_ids: [1]`))
+		if err := b.Update(context.Background(), ds); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		expect := keys("motion/5/title", "motion/5/submitter_ids", "motion_submitter/1/weight")
+		if diff := cmpSet(set(expect...), set(b.Keys()...)); diff != nil {
+			t.Errorf("Got keys %v, expected %v", diff, expect)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		b, err := presets.Builder("user", map[string]string{"id": "7"})
+		if err != nil {
+			t.Fatalf("Builder: %v", err)
+		}
+
+		if err := b.Update(context.Background(), dsmock.Stub(nil)); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		expect := keys("user/7/username")
+		if diff := cmpSet(set(expect...), set(b.Keys()...)); diff != nil {
+			t.Errorf("Got keys %v, expected %v", diff, expect)
+		}
+	})
+
+	t.Run("same fingerprint as body", func(t *testing.T) {
+		b, err := presets.Builder("user", map[string]string{"id": "7"})
+		if err != nil {
+			t.Fatalf("Builder: %v", err)
+		}
+
+		fromBody, err := keysbuilder.ManyFromJSON(strings.NewReader(`[{"ids": [7], "collection": "user", "fields": {"username": null}}]`))
+		if err != nil {
+			t.Fatalf("ManyFromJSON: %v", err)
+		}
+
+		if b.Fingerprint() != fromBody.Fingerprint() {
+			t.Errorf("got fingerprint %s, expected %s", b.Fingerprint(), fromBody.Fingerprint())
+		}
+	})
+
+	t.Run("missing parameter", func(t *testing.T) {
+		_, err := presets.Builder("motion_detail", nil)
+
+		var errInvalid keysbuilder.InvalidError
+		if !errors.As(err, &errInvalid) {
+			t.Errorf("got error %v, expected an InvalidError", err)
+		}
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		_, err := presets.Builder("unknown", nil)
+
+		var errInvalid keysbuilder.InvalidError
+		if !errors.As(err, &errInvalid) {
+			t.Errorf("got error %v, expected an InvalidError", err)
+		}
+	})
+}
+
+func TestPresetsInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			"invalid request",
+			map[string]string{"broken.json": `{"ids": ["$id"], "collection": "user"}`},
+		},
+		{
+			"invalid yaml",
+			map[string]string{"broken.yml": `ids: [`},
+		},
+		{
+			"duplicate name",
+			map[string]string{
+				"user.json": `{"ids": [1], "collection": "user", "fields": {"username": null}}`,
+				"user.yml":  `{"ids": [1], "collection": "user", "fields": {"username": null}}`,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keysbuilder.LoadPresets(writePresets(t, tt.files)); err == nil {
+				t.Errorf("LoadPresets did not return an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package restrict
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsfetch"
+)
+
+// PermissionClasser implements the autoupdate.PermissionClasser interface.
+//
+// Users with the same permission class get the same restricted data, as long
+// as the restricter does not compare the data with the user id. This is
+// tracked with TrackUserSpecific.
+type PermissionClasser struct{}
+
+// PermissionClass returns a string, that is the same for all users with the
+// same permissions.
+//
+// The class contains the organization management level, the committees the
+// user can manage and the groups of the user in each meeting. These are all
+// values the restricter uses, besides the user id itself.
+func (PermissionClasser) PermissionClass(ctx context.Context, getter datastore.Getter, uid int) (string, error) {
+	return PermissionClass(ctx, getter, uid)
+}
+
+// TrackUserSpecific returns a context for the restricter and a function that
+// reports, if the restricted data depends on the user id.
+func (PermissionClasser) TrackUserSpecific(ctx context.Context) (context.Context, func() bool) {
+	return TrackUserSpecific(ctx)
+}
+
+// PermissionClass returns a string, that is the same for all users with the
+// same permissions.
+func PermissionClass(ctx context.Context, getter datastore.Getter, uid int) (string, error) {
+	if uid == 0 {
+		return "anonymous", nil
+	}
+
+	ds := dsfetch.New(getter)
+
+	oml, err := ds.User_OrganizationManagementLevel(uid).Value(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting organization management level: %w", err)
+	}
+
+	committeeIDs, err := ds.User_CommitteeManagementLevel(uid, "can_manage").Value(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting committee management level: %w", err)
+	}
+
+	meetingIDs, err := ds.User_GroupIDsTmpl(uid).Value(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting meetings: %w", err)
+	}
+
+	groupIDs := make([][]int, len(meetingIDs))
+	for i, meetingID := range meetingIDs {
+		ds.User_GroupIDs(uid, meetingID).Lazy(&groupIDs[i])
+	}
+
+	if err := ds.Execute(ctx); err != nil {
+		return "", fmt.Errorf("getting groups: %w", err)
+	}
+
+	var class strings.Builder
+	class.WriteString("oml:" + oml)
+	class.WriteString(";cml:" + joinSorted(committeeIDs))
+
+	meetings := make([]string, 0, len(meetingIDs))
+	for i, meetingID := range meetingIDs {
+		if len(groupIDs[i]) == 0 {
+			continue
+		}
+		meetings = append(meetings, strconv.Itoa(meetingID)+":"+joinSorted(groupIDs[i]))
+	}
+	sort.Strings(meetings)
+	class.WriteString(";groups:" + strings.Join(meetings, ","))
+
+	return class.String(), nil
+}
+
+// joinSorted returns the sorted ids as comma separated string.
+func joinSorted(ids []int) string {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+
+	parts := make([]string, len(sorted))
+	for i, id := range sorted {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+type contextKey int
+
+const userSpecificKey contextKey = iota
+
+// TrackUserSpecific returns a context for the restricter and a function that
+// reports, if the restricter compared data with the user id while using the
+// context.
+func TrackUserSpecific(ctx context.Context) (context.Context, func() bool) {
+	var userSpecific atomic.Bool
+	return context.WithValue(ctx, userSpecificKey, &userSpecific), userSpecific.Load
+}
+
+// markUserSpecific marks the restricted data of the context as user specific.
+func markUserSpecific(ctx context.Context) {
+	if userSpecific, ok := ctx.Value(userSpecificKey).(*atomic.Bool); ok {
+		userSpecific.Store(true)
+	}
+}
@@ -0,0 +1,104 @@
+package restrict_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dsmock"
+)
+
+func TestPermissionClass(t *testing.T) {
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	user:
+		1:
+			group_$_ids: ["30","2"]
+			group_$30_ids: [10, 11]
+			group_$2_ids: [2]
+		2:
+			group_$_ids: ["2","30"]
+			group_$30_ids: [11, 10]
+			group_$2_ids: [2]
+		3:
+			group_$_ids: ["30"]
+			group_$30_ids: [10]
+		4:
+			organization_management_level: can_manage_users
+			group_$_ids: ["30"]
+			group_$30_ids: [10]
+		5:
+			committee_$_management_level: ["can_manage"]
+			committee_$can_manage_management_level: [1]
+			group_$_ids: ["30"]
+			group_$30_ids: [10]
+	`))
+
+	class := func(uid int) string {
+		c, err := restrict.PermissionClass(context.Background(), ds, uid)
+		if err != nil {
+			t.Fatalf("PermissionClass(%d): %v", uid, err)
+		}
+		return c
+	}
+
+	if class(1) != class(2) {
+		t.Errorf("user 1 and 2 have different classes: %q and %q", class(1), class(2))
+	}
+
+	for _, uid := range []int{0, 3, 4, 5} {
+		if class(uid) == class(1) {
+			t.Errorf("user %d has the same class as user 1: %q", uid, class(uid))
+		}
+	}
+
+	if class(3) == class(4) || class(3) == class(5) {
+		t.Errorf("management levels are not part of the class")
+	}
+}
+
+func TestTrackUserSpecific(t *testing.T) {
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	meeting/30/id: 30
+	user/1:
+		group_$_ids: ["30"]
+		group_$30_ids: [10]
+	group/10:
+		meeting_id: 30
+		permissions:
+		- agenda_item.can_see
+	agenda_item/1:
+		meeting_id: 30
+		item_number: one
+	personal_note/1:
+		user_id: 1
+		meeting_id: 30
+		note: my note
+	`))
+
+	for _, tt := range []struct {
+		name   string
+		key    dskey.Key
+		expect bool
+	}{
+		{"group permission", dskey.MustKey("agenda_item/1/item_number"), false},
+		{"own object", dskey.MustKey("personal_note/1/note"), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, userSpecific := restrict.TrackUserSpecific(context.Background())
+
+			got, err := restrict.Middleware(ds, 1).Get(ctx, tt.key)
+			if err != nil {
+				t.Fatalf("Restrict returned: %v", err)
+			}
+
+			if got[tt.key] == nil {
+				t.Errorf("%s got restricted", tt.key)
+			}
+
+			if userSpecific() != tt.expect {
+				t.Errorf("user specific is %t, expected %t", userSpecific(), tt.expect)
+			}
+		})
+	}
+}
@@ -31,7 +31,7 @@ func Allways(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermiss
 }
 
 func loggedIn(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, elementIDs ...int) ([]int, error) {
-	if mperms.UserID() != 0 {
+	if mperms.LoggedIn() {
 		return elementIDs, nil
 	}
 	return nil, nil
@@ -36,7 +36,7 @@ func (a Committee) Modes(mode string) FieldRestricter {
 }
 
 func (a Committee) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, committeeIDs ...int) ([]int, error) {
-	hasOMLPerm, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageUsers)
+	hasOMLPerm, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageUsers)
 	if err != nil {
 		return nil, fmt.Errorf("checking oml perm: %w", err)
 	}
@@ -67,7 +67,7 @@ func (a Committee) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.Meet
 }
 
 func (a Committee) modeB(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, committeeIDs ...int) ([]int, error) {
-	hasOMLPerm, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageOrganization)
+	hasOMLPerm, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageOrganization)
 	if err != nil {
 		return nil, fmt.Errorf("checking oml: %w", err)
 	}
@@ -77,7 +77,7 @@ func (a Committee) modeB(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.Me
 	}
 
 	allowed, err := eachCondition(committeeIDs, func(committeeID int) (bool, error) {
-		cmlCanManage, err := perm.HasCommitteeManagementLevel(ctx, ds, mperms.UserID(), committeeID)
+		cmlCanManage, err := mperms.HasCommitteeManagementLevel(ctx, committeeID)
 		if err != nil {
 			return false, fmt.Errorf("checking committee management level: %w", err)
 		}
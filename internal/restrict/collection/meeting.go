@@ -48,7 +48,7 @@ func (m Meeting) Modes(mode string) FieldRestricter {
 }
 
 func (m Meeting) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, meetingIDs ...int) ([]int, error) {
-	oml, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageOrganization)
+	oml, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageOrganization)
 	if err != nil {
 		return nil, fmt.Errorf("checking organization management level: %w", err)
 	}
@@ -66,7 +66,7 @@ func (m Meeting) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.Meetin
 			return true, nil
 		}
 
-		if mperms.UserID() == 0 {
+		if !mperms.LoggedIn() {
 			return false, nil
 		}
 
@@ -85,7 +85,7 @@ func (m Meeting) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.Meetin
 			return false, fmt.Errorf("getting committee id of meeting: %w", err)
 		}
 
-		isCommitteeManager, err := perm.HasCommitteeManagementLevel(ctx, ds, mperms.UserID(), committeeID)
+		isCommitteeManager, err := mperms.HasCommitteeManagementLevel(ctx, committeeID)
 		if err != nil {
 			return false, fmt.Errorf("getting committee management status: %w", err)
 		}
@@ -99,7 +99,7 @@ func (m Meeting) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.Meetin
 			return false, fmt.Errorf("getting template meeting: %w", err)
 		}
 
-		cmlMeetings, err := perm.ManagementLevelCommittees(ctx, ds, mperms.UserID())
+		cmlMeetings, err := mperms.ManagementLevelCommittees(ctx)
 		if err != nil {
 			return false, fmt.Errorf("getting meetings with cml can manage: %w", err)
 		}
@@ -38,7 +38,7 @@ func (o Organization) Modes(mode string) FieldRestricter {
 }
 
 func (Organization) modeC(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, userIDs ...int) ([]int, error) {
-	isUserManager, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageUsers)
+	isUserManager, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageUsers)
 	if err != nil {
 		return nil, fmt.Errorf("check organization management level: %w", err)
 	}
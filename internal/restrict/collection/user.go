@@ -84,7 +84,7 @@ func (User) SuperAdmin(mode string) FieldRestricter {
 
 // TODO: this is not good.
 func (u User) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, userIDs ...int) ([]int, error) {
-	isUserManager, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageUsers)
+	isUserManager, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageUsers)
 	if err != nil {
 		return nil, fmt.Errorf("check organization management level: %w", err)
 	}
@@ -98,8 +98,8 @@ func (u User) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 			return true, nil
 		}
 
-		if mperms.UserID() != 0 {
-			commiteeIDs, err := perm.ManagementLevelCommittees(ctx, ds, mperms.UserID())
+		if mperms.LoggedIn() {
+			commiteeIDs, err := mperms.ManagementLevelCommittees(ctx)
 			if err != nil {
 				return false, fmt.Errorf("getting committee ids: %w", err)
 			}
@@ -133,7 +133,7 @@ func (u User) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 				return false, fmt.Errorf("getting committee id of meeting %d: %w", meetingID, err)
 			}
 
-			committeeManager, err := perm.HasCommitteeManagementLevel(ctx, ds, mperms.UserID(), cid)
+			committeeManager, err := mperms.HasCommitteeManagementLevel(ctx, cid)
 			if err != nil {
 				return false, fmt.Errorf("getting committee management level: %w", err)
 			}
@@ -143,7 +143,7 @@ func (u User) see(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 			}
 		}
 
-		if mperms.UserID() != 0 {
+		if mperms.LoggedIn() {
 			for _, meetingID := range ds.User_VoteDelegatedToIDTmpl(mperms.UserID()).ErrorLater(ctx) {
 				delegated := ds.User_VoteDelegatedToID(mperms.UserID(), meetingID).ErrorLater(ctx)
 				if delegated == userID {
@@ -277,7 +277,7 @@ func (User) modeB(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 }
 
 func (User) modeD(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, userIDs ...int) ([]int, error) {
-	canManage, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageUsers)
+	canManage, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageUsers)
 	if err != nil {
 		return nil, fmt.Errorf("cheching oml: %w", err)
 	}
@@ -308,11 +308,11 @@ func (User) modeD(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 }
 
 func (User) modeE(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, userIDs ...int) ([]int, error) {
-	if mperms.UserID() == 0 {
+	if !mperms.LoggedIn() {
 		return nil, nil
 	}
 
-	canManage, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageUsers)
+	canManage, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageUsers)
 	if err != nil {
 		return nil, fmt.Errorf("cheching oml: %w", err)
 	}
@@ -327,7 +327,7 @@ func (User) modeE(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 			return true, nil
 		}
 
-		commiteeIDs, err := perm.ManagementLevelCommittees(ctx, ds, mperms.UserID())
+		commiteeIDs, err := mperms.ManagementLevelCommittees(ctx)
 		if err != nil {
 			return false, fmt.Errorf("getting committee ids: %w", err)
 		}
@@ -364,7 +364,7 @@ func (User) modeE(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPe
 }
 
 func (User) modeF(ctx context.Context, ds *dsfetch.Fetch, mperms *perm.MeetingPermission, userIDs ...int) ([]int, error) {
-	isUserManager, err := perm.HasOrganizationManagementLevel(ctx, ds, mperms.UserID(), perm.OMLCanManageUsers)
+	isUserManager, err := mperms.HasOrganizationManagementLevel(ctx, perm.OMLCanManageUsers)
 	if err != nil {
 		return nil, fmt.Errorf("check organization management level: %w", err)
 	}
@@ -14,14 +14,18 @@ type MeetingPermission struct {
 	perms map[int]*Permission
 	ds    *dsfetch.Fetch
 	uid   int
+
+	// userSpecific is set to true, when UserID is called.
+	userSpecific *bool
 }
 
 // NewMeetingPermission initializes a new MeetingPermission.
 func NewMeetingPermission(ds *dsfetch.Fetch, uid int) *MeetingPermission {
 	p := MeetingPermission{
-		perms: make(map[int]*Permission),
-		ds:    ds,
-		uid:   uid,
+		perms:        make(map[int]*Permission),
+		ds:           ds,
+		uid:          uid,
+		userSpecific: new(bool),
 	}
 	return &p
 }
@@ -41,6 +45,40 @@ func (p MeetingPermission) Meeting(ctx context.Context, meetingID int) (*Permiss
 }
 
 // UserID returns the user id the object was initialized with.
+//
+// Calling UserID marks the permission as user specific. Use the other methods,
+// if the result only depends on the permissions of the user and not on the
+// user itself.
 func (p MeetingPermission) UserID() int {
+	*p.userSpecific = true
 	return p.uid
 }
+
+// UserSpecific returns true, if UserID was called. In this case, the result of
+// the restriction can be different for users with the same permissions.
+func (p MeetingPermission) UserSpecific() bool {
+	return *p.userSpecific
+}
+
+// LoggedIn returns true, if the user is not anonymous.
+func (p MeetingPermission) LoggedIn() bool {
+	return p.uid != 0
+}
+
+// HasOrganizationManagementLevel returns true if the user has the level or a
+// higher level.
+func (p MeetingPermission) HasOrganizationManagementLevel(ctx context.Context, level OrganizationManagementLevel) (bool, error) {
+	return HasOrganizationManagementLevel(ctx, p.ds, p.uid, level)
+}
+
+// HasCommitteeManagementLevel returns true, if the user has the manager level
+// in the given committee.
+func (p MeetingPermission) HasCommitteeManagementLevel(ctx context.Context, committeeID int) (bool, error) {
+	return HasCommitteeManagementLevel(ctx, p.ds, p.uid, committeeID)
+}
+
+// ManagementLevelCommittees returns all committee ids where the user has the
+// management level.
+func (p MeetingPermission) ManagementLevelCommittees(ctx context.Context) ([]int, error) {
+	return ManagementLevelCommittees(ctx, p.ds, p.uid)
+}
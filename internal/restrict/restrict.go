@@ -70,8 +70,7 @@ func restrict(ctx context.Context, getter datastore.Getter, uid int, data map[ds
 	if err != nil {
 		var errDoesNotExist dsfetch.DoesNotExistError
 		if errors.As(err, &errDoesNotExist) || dskey.Key(errDoesNotExist).Collection == "user" {
-			// TODO LAST ERROR
-			return nil, fmt.Errorf("request user %d does not exist", uid)
+			return nil, userNotExistError(uid)
 		}
 		return nil, fmt.Errorf("checking for superadmin: %w", err)
 	}
@@ -127,6 +126,10 @@ func restrict(ctx context.Context, getter datastore.Getter, uid int, data map[ds
 		times[cm.Collection+"/"+cm.Mode] = timeCount{time: duration, count: idsCount}
 	}
 
+	if mperms.UserSpecific() {
+		markUserSpecific(ctx)
+	}
+
 	// Remove restricted keys.
 	for key := range data {
 		if data[key] == nil {
@@ -200,6 +203,10 @@ func restrictSuperAdmin(ctx context.Context, getter datastore.Getter, uid int, d
 			data[key] = nil
 		}
 	}
+
+	if mperms.UserSpecific() {
+		markUserSpecific(ctx)
+	}
 	return nil
 }
 
@@ -582,3 +589,25 @@ var collectionOrder = map[string]int{
 func FieldsForCollection(collection string) []string {
 	return collectionFields[collection]
 }
+
+// Collections returns the names of all collections in alphabetical order.
+func Collections() []string {
+	collections := make([]string, 0, len(collectionFields))
+	for collection := range collectionFields {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// userNotExistError is returned, when the request user does not exist. This
+// can happen, when the user is deleted while it is connected.
+type userNotExistError int
+
+func (e userNotExistError) Error() string {
+	return fmt.Sprintf("request user %d does not exist", int(e))
+}
+
+func (e userNotExistError) Type() string {
+	return "user_not_exist"
+}
@@ -7,10 +7,12 @@ import (
 	"log"
 	gohttp "net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/http"
+	"github.com/OpenSlides/openslides-autoupdate-service/internal/keysbuilder"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/metric"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/restrict"
@@ -24,8 +26,17 @@ import (
 //go:generate  sh -c "go run main.go build-doc > environment.md"
 
 var (
-	envAutoupdatePort = environment.NewVariable("AUTOUPDATE_PORT", "9012", "Port on which the service listen on.")
-	envMetricInterval = environment.NewVariable("METRIC_INTERVAL", "5m", "Time in how often the metrics are gathered. Zero disables the metrics.")
+	envAutoupdatePort  = environment.NewVariable("AUTOUPDATE_PORT", "9012", "Port on which the service listen on.")
+	envMetricInterval  = environment.NewVariable("METRIC_INTERVAL", "5m", "Time in how often the metrics are gathered. Zero disables the metrics.")
+	envHeartbeat       = environment.NewVariable("AUTOUPDATE_HEARTBEAT", "0s", "Time after that an empty message is sent to a client, if there was no data. Zero disables the heartbeat. Only enable it, if all clients ignore messages without data.")
+	envMaxLag          = environment.NewVariable("AUTOUPDATE_MAX_LAG", "0s", "Time a client can fall behind the updates, before its connection is closed with the error too_slow. Zero disables the limit.")
+	envCoalesceWindow  = environment.NewVariable("AUTOUPDATE_COALESCE_WINDOW", "0s", "Time in which all changes are collected and sent to the clients in one message. Zero disables it.")
+	envPositionTimeout = environment.NewVariable("AUTOUPDATE_POSITION_TIMEOUT", "10s", "Time a request with the query parameter min_position waits for the position.")
+	envPresetDir       = environment.NewVariable("AUTOUPDATE_PRESET_DIR", "", "Directory with the presets for keys requests. Empty disables the presets.")
+	envKeysMaxDepth    = environment.NewVariable("AUTOUPDATE_KEYS_MAX_DEPTH", "20", "Maximum nesting of relations in a keys request. Zero disables the limit.")
+	envKeysMaxKeys     = environment.NewVariable("AUTOUPDATE_KEYS_MAX_KEYS", "1000000", "Maximum number of keys, that a keys request can build. Zero disables the limit.")
+	envKeysMaxIter     = environment.NewVariable("AUTOUPDATE_KEYS_MAX_ITERATIONS", "50", "Maximum number of times, a keys request fetches values to follow relations. Zero disables the limit.")
+	envAllowedOrigins  = environment.NewVariable("AUTOUPDATE_ALLOWED_ORIGINS", "", "Comma separated list of origins of other websites, that can open a websocket. The host of the service is always allowed.")
 )
 
 var cli struct {
@@ -149,7 +160,23 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 	backgroundTasks = append(backgroundTasks, authBackground)
 
 	// Autoupdate Service.
-	auService, auBackground := autoupdate.New(datastoreService, restrict.Middleware)
+	maxLag, err := environment.ParseDuration(envMaxLag.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `AUTOUPDATE_MAX_LAG`, expected duration got %s: %w", envMaxLag.Value(lookup), err)
+	}
+
+	coalesceWindow, err := environment.ParseDuration(envCoalesceWindow.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `AUTOUPDATE_COALESCE_WINDOW`, expected duration got %s: %w", envCoalesceWindow.Value(lookup), err)
+	}
+
+	auService, auBackground := autoupdate.New(
+		datastoreService,
+		restrict.Middleware,
+		autoupdate.WithPermissionClasser(restrict.PermissionClasser{}),
+		autoupdate.WithMaxLag(maxLag),
+		autoupdate.WithCoalesceWindow(coalesceWindow),
+	)
 	backgroundTasks = append(backgroundTasks, auBackground)
 
 	// Start metrics.
@@ -166,6 +193,36 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 		backgroundTasks = append(backgroundTasks, runMetirc)
 	}
 
+	heartbeat, err := environment.ParseDuration(envHeartbeat.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `AUTOUPDATE_HEARTBEAT`, expected duration got %s: %w", envHeartbeat.Value(lookup), err)
+	}
+
+	positionTimeout, err := environment.ParseDuration(envPositionTimeout.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `AUTOUPDATE_POSITION_TIMEOUT`, expected duration got %s: %w", envPositionTimeout.Value(lookup), err)
+	}
+
+	keysLimits, err := keysLimitsFromEnv(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	var presets *keysbuilder.Presets
+	if dir := envPresetDir.Value(lookup); dir != "" {
+		presets, err = keysbuilder.LoadPresets(dir)
+		if err != nil {
+			return nil, fmt.Errorf("loading presets: %w", err)
+		}
+	}
+
+	var allowedOrigins []string
+	if origins := envAllowedOrigins.Value(lookup); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			allowedOrigins = append(allowedOrigins, strings.TrimSpace(origin))
+		}
+	}
+
 	service := func(ctx context.Context) error {
 		for _, bg := range backgroundTasks {
 			go bg(ctx, oserror.Handle)
@@ -173,8 +230,38 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 
 		// Start http server.
 		fmt.Printf("Listen on %s\n", listenAddr)
-		return http.Run(ctx, listenAddr, authService, auService)
+		return http.Run(
+			ctx,
+			listenAddr,
+			authService,
+			auService,
+			http.WithHeartbeat(heartbeat),
+			http.WithPositionTimeout(positionTimeout),
+			http.WithPresets(presets),
+			http.WithKeysLimits(keysLimits),
+			http.WithAllowedOrigins(allowedOrigins),
+		)
 	}
 
 	return service, nil
 }
+
+// keysLimitsFromEnv reads the limits for keys requests from the environment.
+func keysLimitsFromEnv(lookup environment.Environmenter) (keysbuilder.Limits, error) {
+	var limits keysbuilder.Limits
+	for _, v := range []struct {
+		env   environment.Variable
+		value *int
+	}{
+		{envKeysMaxDepth, &limits.MaxDepth},
+		{envKeysMaxKeys, &limits.MaxKeys},
+		{envKeysMaxIter, &limits.MaxIterations},
+	} {
+		value, err := strconv.Atoi(v.env.Value(lookup))
+		if err != nil || value < 0 {
+			return keysbuilder.Limits{}, fmt.Errorf("invalid value for `%s`, expected a positive number got %s", v.env.Key, v.env.Value(lookup))
+		}
+		*v.value = value
+	}
+	return limits, nil
+}
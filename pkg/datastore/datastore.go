@@ -7,6 +7,7 @@ package datastore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -44,9 +45,36 @@ type Source interface {
 	Updater
 }
 
+// Positioner is a source, that knows the positions of the datastore.
+//
+// If the default source implements the interface, the current position is
+// fetched, when the datastore starts.
+type Positioner interface {
+	CurrentPosition(ctx context.Context) (int, error)
+
+	// PositionAt returns the last position, that was created before or at
+	// the given time. Returns 0, if there is no such position.
+	PositionAt(ctx context.Context, t time.Time) (int, error)
+}
+
+// PositionUpdater is an Updater, that knows the position of each update.
+//
+// If the default source implements the interface, the position of its updates
+// is used as the position of the datastore. UpdateWithPosition returns 0, if
+// the position of an update is unknown.
+type PositionUpdater interface {
+	UpdateWithPosition(ctx context.Context) (map[dskey.Key][]byte, int, error)
+}
+
+// ErrNoPosition is returned by WaitForPosition, if the default source can not
+// report the positions of its updates.
+var ErrNoPosition = errors.New("the datastore does not know the positions of its updates")
+
 // HistoryInformationer returns the history information.
 type HistoryInformationer interface {
 	HistoryInformation(ctx context.Context, fqid string, w io.Writer) error
+	HistoryInformationMany(ctx context.Context, fqids []string, w io.Writer) error
+	HistoryInformationFiltered(ctx context.Context, fqids []string, filter HistoryFilter, w io.Writer) error
 	GetPosition(ctx context.Context, position int, key ...dskey.Key) (map[dskey.Key][]byte, error)
 }
 
@@ -67,6 +95,20 @@ type Datastore struct {
 
 	resetMu sync.Mutex
 
+	// position is the datastore position of the last update.
+	position int64
+
+	// positionMu protects positionChanged and positionUnknown. It is only
+	// needed to change the position. Position() reads it without the lock.
+	positionMu sync.Mutex
+
+	// positionChanged gets closed, when the position changes.
+	positionChanged chan struct{}
+
+	// positionUnknown is true, if the last update of the default source had no
+	// position.
+	positionUnknown bool
+
 	metricGetHitCount uint64
 }
 
@@ -173,13 +215,142 @@ func (d *Datastore) HistoryInformation(ctx context.Context, fqid string, w io.Wr
 	return d.history.HistoryInformation(ctx, fqid, w)
 }
 
+// HistoryInformationMany writes the history information for many fqids.
+//
+// The format is an object from the fqid to a list of entries.
+func (d *Datastore) HistoryInformationMany(ctx context.Context, fqids []string, w io.Writer) error {
+	return d.history.HistoryInformationMany(ctx, fqids, w)
+}
+
+// HistoryInformationFiltered writes the history information for many fqids
+// with only the entries, that match the filter.
+//
+// If the filter has a limit, the information is written in a page with a
+// cursor for the next page.
+func (d *Datastore) HistoryInformationFiltered(ctx context.Context, fqids []string, filter HistoryFilter, w io.Writer) error {
+	return d.history.HistoryInformationFiltered(ctx, fqids, filter, w)
+}
+
+// Position returns the datastore position of the last update.
+//
+// The position is sent by the default source with each update. The data is at
+// least at this position. Returns 0, if the default source does not know the
+// position.
+func (d *Datastore) Position() int {
+	return int(atomic.LoadInt64(&d.position))
+}
+
+// PositionAt returns the last position, that was created before or at the
+// given time. Returns 0, if there is no such position.
+func (d *Datastore) PositionAt(ctx context.Context, t time.Time) (int, error) {
+	positioner, ok := d.defaultSource.(Positioner)
+	if !ok {
+		return 0, fmt.Errorf("positions by time are not supported")
+	}
+
+	position, err := positioner.PositionAt(ctx, t)
+	if err != nil {
+		return 0, fmt.Errorf("getting position at %s: %w", t, err)
+	}
+	return position, nil
+}
+
+// WaitForPosition blocks until the datastore has processed the update with
+// the given position or the context is done.
+//
+// Returns ErrNoPosition, if the position can not be reached, since the default
+// source does not report the positions of its updates.
+func (d *Datastore) WaitForPosition(ctx context.Context, position int) error {
+	for {
+		d.positionMu.Lock()
+		if d.Position() >= position {
+			d.positionMu.Unlock()
+			return nil
+		}
+
+		if d.positionUnknown {
+			d.positionMu.Unlock()
+			return ErrNoPosition
+		}
+
+		if d.positionChanged == nil {
+			d.positionChanged = make(chan struct{})
+		}
+		changed := d.positionChanged
+		d.positionMu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setPosition saves the position, if it is newer then the current one, and
+// wakes all calls to WaitForPosition.
+//
+// known is false, if the update had no position.
+func (d *Datastore) setPosition(position int, known bool) {
+	d.positionMu.Lock()
+	defer d.positionMu.Unlock()
+
+	changed := d.positionUnknown != !known
+	d.positionUnknown = !known
+
+	if position > d.Position() {
+		atomic.StoreInt64(&d.position, int64(position))
+		changed = true
+	}
+
+	if changed && d.positionChanged != nil {
+		close(d.positionChanged)
+		d.positionChanged = nil
+	}
+}
+
+// currentPosition fetches the position from the default source, if it
+// implements the Positioner interface.
+//
+// Returns 0, if the position is unknown.
+func (d *Datastore) currentPosition(ctx context.Context) (int, error) {
+	positioner, ok := d.defaultSource.(Positioner)
+	if !ok {
+		return 0, nil
+	}
+
+	position, err := positioner.CurrentPosition(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting position: %w", err)
+	}
+	return position, nil
+}
+
+// sourceUpdate is the data of an update and its position.
+//
+// The position is only set for updates of the default source.
+type sourceUpdate struct {
+	data          map[dskey.Key][]byte
+	position      int
+	defaultSource bool
+}
+
 // listenOnUpdates listens for updates and informs all listeners.
 func (d *Datastore) listenOnUpdates(ctx context.Context, errHandler func(error)) {
 	if errHandler == nil {
 		errHandler = func(error) {}
 	}
 
-	updatedValues := make(chan map[dskey.Key][]byte)
+	// The cache is empty at the start. So all data is read after the current
+	// position.
+	position, err := d.currentPosition(ctx)
+	if err != nil {
+		errHandler(err)
+	}
+	_, withPositions := d.defaultSource.(PositionUpdater)
+	d.setPosition(position, withPositions)
+
+	updatedValues := make(chan sourceUpdate)
 	sources := make([]Source, 0, len(d.keySource)+1)
 	sources = append(sources, d.defaultSource)
 	for _, s := range d.keySource {
@@ -192,7 +363,7 @@ func (d *Datastore) listenOnUpdates(ctx context.Context, errHandler func(error))
 		go func(source Source) {
 			defer wg.Done()
 			for {
-				data, err := source.Update(ctx)
+				update, err := d.sourceUpdate(ctx, source)
 				if err != nil {
 					if oserror.ContextDone(err) {
 						return
@@ -202,7 +373,8 @@ func (d *Datastore) listenOnUpdates(ctx context.Context, errHandler func(error))
 					time.Sleep(messageBusReconnectPause)
 					continue
 				}
-				updatedValues <- data
+
+				updatedValues <- update
 			}
 		}(source)
 	}
@@ -212,7 +384,9 @@ func (d *Datastore) listenOnUpdates(ctx context.Context, errHandler func(error))
 		close(updatedValues)
 	}()
 
-	for data := range updatedValues {
+	for update := range updatedValues {
+		data := update.data
+
 		// The lock prefents a cache reset while data is updating.
 		d.resetMu.Lock()
 		d.cache.SetIfExistMany(data)
@@ -226,6 +400,13 @@ func (d *Datastore) listenOnUpdates(ctx context.Context, errHandler func(error))
 			data[key] = bs
 		}
 
+		// The position is set after the cache and the calculated fields. So
+		// all data in the cache is at least at the position. An empty update
+		// without a position happens, when the source times out.
+		if update.defaultSource && (update.position != 0 || len(data) > 0) {
+			d.setPosition(update.position, update.position != 0)
+		}
+
 		for _, f := range d.changeListeners {
 			if err := f(data); err != nil {
 				errHandler(err)
@@ -235,6 +416,23 @@ func (d *Datastore) listenOnUpdates(ctx context.Context, errHandler func(error))
 	}
 }
 
+// sourceUpdate waits for the next update of the source.
+func (d *Datastore) sourceUpdate(ctx context.Context, source Source) (sourceUpdate, error) {
+	if source != d.defaultSource {
+		data, err := source.Update(ctx)
+		return sourceUpdate{data: data}, err
+	}
+
+	positionUpdater, ok := source.(PositionUpdater)
+	if !ok {
+		data, err := source.Update(ctx)
+		return sourceUpdate{data: data, defaultSource: true}, err
+	}
+
+	data, position, err := positionUpdater.UpdateWithPosition(ctx)
+	return sourceUpdate{data: data, position: position, defaultSource: true}, err
+}
+
 // splitCalculateddskey.Key splits a list of keys in calculated keys and "normal"
 // keys. The calculated keys are returned as map that point to the field name.
 func (d *Datastore) splitCalculatedKeys(keys []dskey.Key) (map[dskey.Key]string, map[Source][]dskey.Key) {
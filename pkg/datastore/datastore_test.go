@@ -2,8 +2,10 @@ package datastore_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -378,6 +380,45 @@ func TestChangeListenersWithCalculatedFields(t *testing.T) {
 	}, receivedData)
 }
 
+func TestPositionAfterCalculatedFields(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := dsmock.NewStubWithUpdate(dsmock.Stub(map[dskey.Key][]byte{}))
+	ds, bg, err := datastore.New(environment.ForTests{}, nil, datastore.WithDefaultSource(source))
+	if err != nil {
+		t.Fatalf("init ds: %v", err)
+	}
+	go bg(shutdownCtx, oserror.Handle)
+
+	release := make(chan struct{})
+	ds.RegisterCalculatedField(myField1, func(ctx context.Context, key dskey.Key, changed map[dskey.Key][]byte) ([]byte, error) {
+		if changed != nil {
+			<-release
+		}
+		return []byte("calculated"), nil
+	})
+
+	// Load calculated field in cache.
+	ds.Get(context.Background(), myCalculated)
+
+	source.Send(dsmock.YAMLData("collection/1/field: my value"))
+
+	ctx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	if err := ds.WaitForPosition(ctx, 1); err == nil {
+		t.Fatalf("WaitForPosition returned before the calculated field was updated")
+	}
+
+	close(release)
+
+	ctx, cancelWait = context.WithTimeout(context.Background(), time.Second)
+	defer cancelWait()
+	if err := ds.WaitForPosition(ctx, 1); err != nil {
+		t.Errorf("WaitForPosition: %v", err)
+	}
+}
+
 func TestResetCache(t *testing.T) {
 	source := dsmock.NewStubWithUpdate(dsmock.Stub(map[dskey.Key][]byte{}), dsmock.NewCounter)
 
@@ -424,3 +465,78 @@ func TestResetWhileUpdate(t *testing.T) {
 	// There is nothing to assert. This test is only for the race detector. Make
 	// sure to run the tests with the -race flag.
 }
+
+// aheadSource is a source, where the current position is already at the next
+// update, that is still on the way.
+type aheadSource struct {
+	*dsmock.StubWithUpdate
+	current int64
+}
+
+func (s *aheadSource) CurrentPosition(ctx context.Context) (int, error) {
+	return int(atomic.LoadInt64(&s.current)), nil
+}
+
+func TestPositionOfUpdate(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := &aheadSource{StubWithUpdate: dsmock.NewStubWithUpdate(dsmock.Stub(map[dskey.Key][]byte{}))}
+
+	ds, bg, err := datastore.New(environment.ForTests{}, nil, datastore.WithDefaultSource(source))
+	if err != nil {
+		t.Fatalf("init ds: %v", err)
+	}
+
+	received := make(chan struct{}, 1)
+	ds.RegisterChangeListener(func(data map[dskey.Key][]byte) error {
+		received <- struct{}{}
+		return nil
+	})
+	go bg(shutdownCtx, oserror.Handle)
+
+	source.Send(dsmock.YAMLData("collection/1/field: first value"))
+	<-received
+
+	atomic.StoreInt64(&source.current, 3)
+	source.Send(dsmock.YAMLData("collection/1/field: second value"))
+	<-received
+
+	if got := ds.Position(); got != 2 {
+		t.Errorf("got position %d, expected the position of the update 2", got)
+	}
+}
+
+// sourceWithoutPositions is a source, that does not know the positions of its
+// updates.
+type sourceWithoutPositions struct {
+	source *dsmock.StubWithUpdate
+}
+
+func (s sourceWithoutPositions) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
+	return s.source.Get(ctx, keys...)
+}
+
+func (s sourceWithoutPositions) Update(ctx context.Context) (map[dskey.Key][]byte, error) {
+	return s.source.Update(ctx)
+}
+
+func TestWaitForPositionWithoutPositions(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := sourceWithoutPositions{source: dsmock.NewStubWithUpdate(dsmock.Stub(map[dskey.Key][]byte{}))}
+
+	ds, bg, err := datastore.New(environment.ForTests{}, nil, datastore.WithDefaultSource(source))
+	if err != nil {
+		t.Fatalf("init ds: %v", err)
+	}
+	go bg(shutdownCtx, oserror.Handle)
+
+	ctx, cancelWait := context.WithTimeout(context.Background(), time.Second)
+	defer cancelWait()
+
+	if err := ds.WaitForPosition(ctx, 1); !errors.Is(err, datastore.ErrNoPosition) {
+		t.Errorf("got error %v, expected ErrNoPosition", err)
+	}
+}
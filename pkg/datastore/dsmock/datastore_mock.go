@@ -175,8 +175,22 @@ func (d *MockDatastore) ResetRequests() {
 
 // HistoryInformation writes a fake history.
 func (d *MockDatastore) HistoryInformation(ctx context.Context, fqid string, w io.Writer) error {
-	w.Write([]byte(`[{"position":42,"user_id": 5,"information": "motion was created","timestamp": 1234567}]`))
-	return nil
+	return writeFakeHistory([]string{fqid}, w)
+}
+
+// HistoryInformationMany writes a fake history for many fqids.
+func (d *MockDatastore) HistoryInformationMany(ctx context.Context, fqids []string, w io.Writer) error {
+	return writeFakeHistory(fqids, w)
+}
+
+// HistoryInformationFiltered writes a fake history for many fqids with only
+// the entries, that match the filter.
+func (d *MockDatastore) HistoryInformationFiltered(ctx context.Context, fqids []string, filter datastore.HistoryFilter, w io.Writer) error {
+	buf := new(bytes.Buffer)
+	if err := writeFakeHistory(fqids, buf); err != nil {
+		return err
+	}
+	return datastore.FilterHistory(buf, filter, w)
 }
 
 // KeysRequested returns true, if all given keys where requested.
@@ -1,11 +1,15 @@
 package dsmock
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 )
 
@@ -40,6 +44,12 @@ type StubWithUpdate struct {
 	getter Getter
 
 	middlewares []Getter
+
+	// position is increased on each update.
+	position int
+
+	// positionTimes is the time of each position.
+	positionTimes []time.Time
 }
 
 // NewStubWithUpdate initializes a the object.
@@ -69,20 +79,59 @@ func (s *StubWithUpdate) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.
 
 // Update blocks until new data is received via the Send method.
 func (s *StubWithUpdate) Update(ctx context.Context) (map[dskey.Key][]byte, error) {
+	data, _, err := s.UpdateWithPosition(ctx)
+	return data, err
+}
+
+// UpdateWithPosition is like Update but also returns the position of the
+// update. Each call to Send creates a new position.
+//
+// It implements the datastore.PositionUpdater interface.
+func (s *StubWithUpdate) UpdateWithPosition(ctx context.Context) (map[dskey.Key][]byte, int, error) {
 	select {
 	case newValues := <-s.ch:
 		s.mu.Lock()
+		defer s.mu.Unlock()
 		for k, v := range newValues {
 			s.stub[k] = v
 		}
-		s.mu.Unlock()
-		return newValues, nil
+		s.position++
+		s.positionTimes = append(s.positionTimes, time.Now())
+		return newValues, s.position, nil
 
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, 0, ctx.Err()
 	}
 }
 
+// CurrentPosition returns the number of updates.
+//
+// It implements the datastore.Positioner interface.
+func (s *StubWithUpdate) CurrentPosition(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.position, nil
+}
+
+// PositionAt returns the last position that was created before or at the
+// given time.
+//
+// It implements the datastore.Positioner interface.
+func (s *StubWithUpdate) PositionAt(ctx context.Context, t time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var position int
+	for i, created := range s.positionTimes {
+		if created.After(t) {
+			break
+		}
+		position = i + 1
+	}
+	return position, nil
+}
+
 // Send sends keys to the mock that can be received with Update().
 func (s *StubWithUpdate) Send(values map[dskey.Key][]byte) {
 	s.ch <- values
@@ -101,8 +150,39 @@ func (s *StubWithUpdate) Middlewares() []Getter {
 
 // HistoryInformation writes a fake history.
 func (s *StubWithUpdate) HistoryInformation(ctx context.Context, fqid string, w io.Writer) error {
-	w.Write([]byte(`[{"position":42,"user_id": 5,"information": "motion was created","timestamp: 1234567}]`))
-	return nil
+	return writeFakeHistory([]string{fqid}, w)
+}
+
+// HistoryInformationMany writes a fake history for many fqids.
+func (s *StubWithUpdate) HistoryInformationMany(ctx context.Context, fqids []string, w io.Writer) error {
+	return writeFakeHistory(fqids, w)
+}
+
+// HistoryInformationFiltered writes a fake history for many fqids with only
+// the entries, that match the filter.
+func (s *StubWithUpdate) HistoryInformationFiltered(ctx context.Context, fqids []string, filter datastore.HistoryFilter, w io.Writer) error {
+	buf := new(bytes.Buffer)
+	if err := writeFakeHistory(fqids, buf); err != nil {
+		return err
+	}
+	return datastore.FilterHistory(buf, filter, w)
+}
+
+// writeFakeHistory writes one entry for each fqid.
+func writeFakeHistory(fqids []string, w io.Writer) error {
+	entry := json.RawMessage(`[{"position":42,"user_id": 5,"information": "motion was created","timestamp": 1234567}]`)
+
+	history := make(map[string]json.RawMessage, len(fqids))
+	for _, fqid := range fqids {
+		history[fqid] = entry
+	}
+	bs, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("encoding history: %w", err)
+	}
+
+	_, err = w.Write(bs)
+	return err
 }
 
 // Counter counts all keys that where requested.
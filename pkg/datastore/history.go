@@ -2,6 +2,10 @@ package datastore
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 )
@@ -29,3 +33,231 @@ func NewGetPosition(g GetPositioner, position int) *GetPosition {
 func (g *GetPosition) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
 	return g.getter.GetPosition(ctx, g.position, keys...)
 }
+
+// HistoryFilter selects the entries of the history information.
+//
+// The zero value selects all entries.
+type HistoryFilter struct {
+	// MaxPosition is the last position, that is returned. 0 means no limit.
+	MaxPosition int
+
+	// BeforePosition only selects entries before this position. 0 means no
+	// limit.
+	BeforePosition int
+
+	// UserID only selects entries, that where created by this user. 0 means
+	// all users.
+	UserID int
+
+	// From and To only select entries, that where created in this time range.
+	// Both are inclusive. The zero time means no limit.
+	From time.Time
+	To   time.Time
+
+	// Limit is the maximum number of entries. If it is set, the newest
+	// entries are returned with a cursor for the next page. 0 means no limit.
+	Limit int
+}
+
+// Empty returns true, if the filter selects all entries.
+func (f HistoryFilter) Empty() bool {
+	return f == HistoryFilter{}
+}
+
+func (f HistoryFilter) match(entry historyEntry) bool {
+	if f.MaxPosition != 0 && entry.Position > f.MaxPosition {
+		return false
+	}
+
+	if f.BeforePosition != 0 && entry.Position >= f.BeforePosition {
+		return false
+	}
+
+	if f.UserID != 0 && entry.UserID != f.UserID {
+		return false
+	}
+
+	if !f.From.IsZero() && entry.Timestamp < float64(f.From.Unix()) {
+		return false
+	}
+
+	if !f.To.IsZero() && entry.Timestamp > float64(f.To.Unix()) {
+		return false
+	}
+
+	return true
+}
+
+// historyEntry is the part of an entry of the history information, that is
+// needed to filter it.
+type historyEntry struct {
+	Position  int     `json:"position"`
+	UserID    int     `json:"user_id"`
+	Timestamp float64 `json:"timestamp"`
+
+	fqid string
+	raw  json.RawMessage
+}
+
+// historyPage is the history information with a limit.
+type historyPage struct {
+	Information map[string][]json.RawMessage `json:"information"`
+
+	// Cursor is the value for BeforePosition to get the next page. It is 0,
+	// if there are no more entries.
+	Cursor int `json:"cursor,omitempty"`
+}
+
+// FilterHistory reads the history information from r and writes only the
+// entries, that match the filter, to w.
+//
+// The history information is an object from the fqids to the list of
+// entries. If the filter has a limit, this object is written in a page with a
+// cursor for the next page.
+//
+// The entries are decoded one by one. Only the entries, that can be part of
+// the result, are kept in memory.
+func FilterHistory(r io.Reader, filter HistoryFilter, w io.Writer) error {
+	selection := historySelection{
+		limit: filter.Limit,
+		count: make(map[int]int),
+	}
+
+	var fqids []string
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return fmt.Errorf("decoding history information: %w", err)
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("decoding fqid: %w", err)
+		}
+		fqid, _ := token.(string)
+		fqids = append(fqids, fqid)
+
+		if err := expectDelim(decoder, '['); err != nil {
+			return fmt.Errorf("decoding entries of %s: %w", fqid, err)
+		}
+
+		for decoder.More() {
+			var entry historyEntry
+			if err := decoder.Decode(&entry.raw); err != nil {
+				return fmt.Errorf("decoding entry of %s: %w", fqid, err)
+			}
+
+			if err := json.Unmarshal(entry.raw, &entry); err != nil {
+				return fmt.Errorf("decoding entry of %s: %w", fqid, err)
+			}
+			entry.fqid = fqid
+
+			if filter.match(entry) {
+				selection.add(entry)
+			}
+		}
+
+		if err := expectDelim(decoder, ']'); err != nil {
+			return fmt.Errorf("decoding entries of %s: %w", fqid, err)
+		}
+	}
+
+	if err := expectDelim(decoder, '}'); err != nil {
+		return fmt.Errorf("decoding history information: %w", err)
+	}
+
+	result := make(map[string][]json.RawMessage, len(fqids))
+	for _, fqid := range fqids {
+		result[fqid] = []json.RawMessage{}
+	}
+	for _, entry := range selection.entries {
+		result[entry.fqid] = append(result[entry.fqid], entry.raw)
+	}
+
+	var value interface{} = result
+	if filter.Limit > 0 {
+		value = historyPage{Information: result, Cursor: selection.cursor()}
+	}
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		return fmt.Errorf("writing history information: %w", err)
+	}
+	return nil
+}
+
+// expectDelim reads the next token and returns an error, if it is not the
+// delimiter.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return fmt.Errorf("got %v, expected %v", token, delim)
+	}
+	return nil
+}
+
+// historySelection keeps the newest entries up to the limit. The entries keep
+// their order.
+//
+// The entries of one position are not split, so the cursor can be used as
+// BeforePosition for the next page. Only if the entries of one position are
+// more then the limit, they are all kept.
+type historySelection struct {
+	limit   int
+	entries []historyEntry
+
+	// count is the number of kept entries for each position.
+	count map[int]int
+
+	// matched is the number of all added entries.
+	matched int
+}
+
+func (s *historySelection) add(entry historyEntry) {
+	s.matched++
+	s.entries = append(s.entries, entry)
+	s.count[entry.Position]++
+
+	if s.limit == 0 {
+		return
+	}
+
+	// The oldest position is not part of the result, if the entries up to it
+	// are more then the limit. This does not change with more entries, so it
+	// can be removed now.
+	for len(s.count) > 1 && len(s.entries) > s.limit {
+		oldest := s.oldest()
+		delete(s.count, oldest)
+
+		kept := s.entries[:0]
+		for _, e := range s.entries {
+			if e.Position != oldest {
+				kept = append(kept, e)
+			}
+		}
+		s.entries = kept
+	}
+}
+
+// oldest returns the oldest kept position.
+func (s *historySelection) oldest() int {
+	oldest := -1
+	for position := range s.count {
+		if oldest == -1 || position < oldest {
+			oldest = position
+		}
+	}
+	return oldest
+}
+
+// cursor returns the value for BeforePosition to get the next page or 0, if
+// there are no more entries.
+func (s *historySelection) cursor() int {
+	if s.limit == 0 || s.matched == len(s.entries) {
+		return 0
+	}
+	return s.oldest()
+}
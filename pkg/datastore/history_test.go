@@ -0,0 +1,131 @@
+package datastore_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore"
+)
+
+func TestFilterHistory(t *testing.T) {
+	raw := `{
+		"motion/1": [
+			{"position": 1, "user_id": 5, "timestamp": 100},
+			{"position": 3, "user_id": 6, "timestamp": 300},
+			{"position": 4, "user_id": 5, "timestamp": 400}
+		],
+		"motion/2": [
+			{"position": 2, "user_id": 5, "timestamp": 200},
+			{"position": 3, "user_id": 6, "timestamp": 300}
+		]
+	}`
+
+	for _, tt := range []struct {
+		name   string
+		filter datastore.HistoryFilter
+		expect map[string][]int
+		cursor int
+	}{
+		{
+			"max position",
+			datastore.HistoryFilter{MaxPosition: 2},
+			map[string][]int{"motion/1": {1}, "motion/2": {2}},
+			0,
+		},
+		{
+			"before position",
+			datastore.HistoryFilter{BeforePosition: 2},
+			map[string][]int{"motion/1": {1}, "motion/2": {}},
+			0,
+		},
+		{
+			"user",
+			datastore.HistoryFilter{UserID: 6},
+			map[string][]int{"motion/1": {3}, "motion/2": {3}},
+			0,
+		},
+		{
+			"time range",
+			datastore.HistoryFilter{From: time.Unix(200, 0), To: time.Unix(300, 0)},
+			map[string][]int{"motion/1": {3}, "motion/2": {2, 3}},
+			0,
+		},
+		{
+			"limit",
+			datastore.HistoryFilter{Limit: 2},
+			map[string][]int{"motion/1": {4}, "motion/2": {}},
+			4,
+		},
+		{
+			"limit does not split a position",
+			datastore.HistoryFilter{Limit: 3},
+			map[string][]int{"motion/1": {3, 4}, "motion/2": {3}},
+			3,
+		},
+		{
+			"next page",
+			datastore.HistoryFilter{Limit: 3, BeforePosition: 3},
+			map[string][]int{"motion/1": {1}, "motion/2": {2}},
+			0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := datastore.FilterHistory(strings.NewReader(raw), tt.filter, buf); err != nil {
+				t.Fatalf("FilterHistory: %v", err)
+			}
+
+			var page struct {
+				Information map[string][]struct {
+					Position int `json:"position"`
+				} `json:"information"`
+				Cursor int `json:"cursor"`
+			}
+			if tt.filter.Limit > 0 {
+				if err := json.Unmarshal(buf.Bytes(), &page); err != nil {
+					t.Fatalf("decoding page: %v", err)
+				}
+			} else {
+				if err := json.Unmarshal(buf.Bytes(), &page.Information); err != nil {
+					t.Fatalf("decoding information: %v", err)
+				}
+			}
+
+			got := make(map[string][]int)
+			for fqid, entries := range page.Information {
+				got[fqid] = []int{}
+				for _, entry := range entries {
+					got[fqid] = append(got[fqid], entry.Position)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("got positions %v, expected %v", got, tt.expect)
+			}
+
+			if page.Cursor != tt.cursor {
+				t.Errorf("got cursor %d, expected %d", page.Cursor, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestFilterHistoryInvalid(t *testing.T) {
+	for _, raw := range []string{
+		`[]`,
+		`{"motion/1": {}}`,
+		`{"motion/1": [{"position": "1"}]}`,
+		`{"motion/1": [`,
+	} {
+		t.Run(raw, func(t *testing.T) {
+			err := datastore.FilterHistory(strings.NewReader(raw), datastore.HistoryFilter{Limit: 1}, new(bytes.Buffer))
+			if err == nil {
+				t.Errorf("FilterHistory returned no error")
+			}
+		})
+	}
+}
@@ -3,13 +3,13 @@ package datastore
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"sort"
 	"strconv"
-	"strings"
 	"sync/atomic"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/internal/oserror"
@@ -198,11 +198,55 @@ func (s *sourceDatastore) getPosition(ctx context.Context, position int, keys ..
 
 // HistoryInformation requests the history information for an fqid from the datastore.
 func (s *sourceDatastore) HistoryInformation(ctx context.Context, fqid string, w io.Writer) error {
+	return s.HistoryInformationMany(ctx, []string{fqid}, w)
+}
+
+// HistoryInformationMany requests the history information for many fqids from
+// the datastore with one request.
+func (s *sourceDatastore) HistoryInformationMany(ctx context.Context, fqids []string, w io.Writer) error {
+	copyResponse := func(r io.Reader) error {
+		if _, err := io.Copy(w, r); err != nil {
+			// TODO External Error
+			return fmt.Errorf("copping datastore response to client: %w", err)
+		}
+		return nil
+	}
+
+	return s.requestHistory(ctx, historyRequest{FQIDs: fqids}, copyResponse)
+}
+
+// HistoryInformationFiltered requests the history information for many fqids
+// and writes only the entries, that match the filter.
+//
+// The reader only supports the fqids. So the response is filtered while it is
+// read.
+func (s *sourceDatastore) HistoryInformationFiltered(ctx context.Context, fqids []string, filter HistoryFilter, w io.Writer) error {
+	filterResponse := func(r io.Reader) error {
+		return FilterHistory(r, filter, w)
+	}
+
+	return s.requestHistory(ctx, historyRequest{FQIDs: fqids}, filterResponse)
+}
+
+// historyRequest is the body of a request to the history information of the
+// datastore reader.
+type historyRequest struct {
+	FQIDs []string `json:"fqids"`
+}
+
+// requestHistory sends the request to the history information of the
+// datastore reader and calls handle with the response body.
+func (s *sourceDatastore) requestHistory(ctx context.Context, request historyRequest, handle func(r io.Reader) error) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+
 	req, err := http.NewRequestWithContext(
 		ctx,
 		"POST",
 		s.url+urlHistoryInformation,
-		strings.NewReader(fmt.Sprintf(`{"fqids":[%q]}`, fqid)),
+		bytes.NewReader(body),
 	)
 	if err != nil {
 		return fmt.Errorf("creating request for datastore: %w", err)
@@ -222,10 +266,5 @@ func (s *sourceDatastore) HistoryInformation(ctx context.Context, fqid string, w
 		return fmt.Errorf("datastore returned %s", resp.Status)
 	}
 
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		// TODO External Error
-		return fmt.Errorf("copping datastore response to client: %w", err)
-	}
-
-	return nil
+	return handle(resp.Body)
 }
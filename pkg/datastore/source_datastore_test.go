@@ -115,3 +115,43 @@ func handleGetMany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func TestSourceHistoryInformationFiltered(t *testing.T) {
+	var request map[string]json.RawMessage
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid json input: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintln(w, `{"motion/1": [{"position":3,"user_id":7},{"position":4,"user_id":7}]}`)
+	}))
+	defer ts.Close()
+
+	host, port, schema := parseURL(ts.URL)
+	env := environment.ForTests(map[string]string{
+		"DATASTORE_READER_HOST":     host,
+		"DATASTORE_READER_PORT":     port,
+		"DATASTORE_READER_PROTOCOL": schema,
+	})
+
+	sd, err := newSourceDatastore(env)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	buf := new(strings.Builder)
+	filter := HistoryFilter{Limit: 1, BeforePosition: 5, UserID: 7}
+	if err := sd.HistoryInformationFiltered(context.Background(), []string{"motion/1"}, filter, buf); err != nil {
+		t.Fatalf("HistoryInformationFiltered: %v", err)
+	}
+
+	if len(request) != 1 || string(request["fqids"]) != `["motion/1"]` {
+		t.Errorf("reader got request %v, expected only the fqids", request)
+	}
+
+	expect := `{"information":{"motion/1":[{"position":4,"user_id":7}]},"cursor":4}`
+	if got := strings.TrimSpace(buf.String()); got != expect {
+		t.Errorf("got %s, expected %s", got, expect)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/environment"
@@ -127,6 +128,41 @@ func (p *SourcePostgres) Update(ctx context.Context) (map[dskey.Key][]byte, erro
 	return p.updater.Update(ctx)
 }
 
+// UpdateWithPosition calls the updater and returns the position of the update.
+//
+// Returns 0 as position, if the updater does not know the position.
+func (p *SourcePostgres) UpdateWithPosition(ctx context.Context) (map[dskey.Key][]byte, int, error) {
+	positionUpdater, ok := p.updater.(PositionUpdater)
+	if !ok {
+		data, err := p.updater.Update(ctx)
+		return data, 0, err
+	}
+
+	return positionUpdater.UpdateWithPosition(ctx)
+}
+
+// CurrentPosition returns the highest position in the datastore.
+//
+// It is only correct, if there are no updates on the way. So it is only used
+// on startup and not after an update.
+func (p *SourcePostgres) CurrentPosition(ctx context.Context) (int, error) {
+	var position int
+	if err := p.pool.QueryRow(ctx, `SELECT COALESCE(MAX(position), 0) FROM positions;`).Scan(&position); err != nil {
+		return 0, fmt.Errorf("query position: %w", err)
+	}
+	return position, nil
+}
+
+// PositionAt returns the last position, that was created before or at the
+// given time.
+func (p *SourcePostgres) PositionAt(ctx context.Context, t time.Time) (int, error) {
+	var position int
+	if err := p.pool.QueryRow(ctx, `SELECT COALESCE(MAX(position), 0) FROM positions WHERE timestamp <= $1;`, t).Scan(&position); err != nil {
+		return 0, fmt.Errorf("query position: %w", err)
+	}
+	return position, nil
+}
+
 func prepareQuery(keys []dskey.Key) (uniqueFieldsStr string, fieldIndex map[string]int, uniqueFQID []string) {
 	uniqueFQIDSet := make(map[string]struct{})
 	uniqueFieldsSet := make(map[string]struct{})
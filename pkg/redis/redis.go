@@ -20,6 +20,10 @@ const (
 	// fieldChangedTopic is the redis key name of the autoupdate stream.
 	fieldChangedTopic = "ModifiedFields"
 
+	// positionField is the field of a message in the autoupdate stream, that
+	// contains the datastore position of the update.
+	positionField = "position"
+
 	// logoutTopic is the redis key name of the logout stream.
 	logoutTopic = "logout"
 
@@ -58,6 +62,16 @@ func New(lookup environment.Environmenter) *Redis {
 
 // Update is a blocking function that returns, when there is new data.
 func (r *Redis) Update(ctx context.Context) (map[dskey.Key][]byte, error) {
+	data, _, err := r.UpdateWithPosition(ctx)
+	return data, err
+}
+
+// UpdateWithPosition is like Update but also returns the datastore position
+// of the update.
+//
+// The position is read from the field position of the messages. Returns 0, if
+// the messages do not have a position.
+func (r *Redis) UpdateWithPosition(ctx context.Context) (map[dskey.Key][]byte, int, error) {
 	id := r.lastAutoupdateID
 	if id == "" {
 		id = "$"
@@ -68,17 +82,17 @@ func (r *Redis) Update(ctx context.Context) (map[dskey.Key][]byte, error) {
 
 	reply, err := redis.DoContext(conn, ctx, "XREAD", "COUNT", maxMessages, "BLOCK", "0", "STREAMS", fieldChangedTopic, id)
 	if err != nil {
-		return nil, fmt.Errorf("redis reply: %w", err)
+		return nil, 0, fmt.Errorf("redis reply: %w", err)
 	}
 
 	if reply == nil {
 		// This happens, when the redis command times out.
-		return nil, nil
+		return nil, 0, nil
 	}
 
-	id, data, err := parseMessageBus(reply)
+	id, data, position, err := parseMessageBus(reply)
 	if err != nil {
-		return nil, fmt.Errorf("parsing message bus: %w", err)
+		return nil, 0, fmt.Errorf("parsing message bus: %w", err)
 	}
 
 	if id != "" {
@@ -86,7 +100,7 @@ func (r *Redis) Update(ctx context.Context) (map[dskey.Key][]byte, error) {
 		r.lastAutoupdateID = id
 	}
 
-	return data, nil
+	return data, position, nil
 }
 
 // LogoutEvent is a blocking function that returns, when a session was revoked.
@@ -3,6 +3,7 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/OpenSlides/openslides-autoupdate-service/pkg/datastore/dskey"
 	"github.com/gomodule/redigo/redis"
@@ -88,9 +89,21 @@ func onlyStream(reply any, only string, f func(k, v []byte)) (string, error) {
 	return "", fmt.Errorf("stream not found")
 }
 
-func parseMessageBus(reply any) (string, map[dskey.Key][]byte, error) {
+// parseMessageBus parses the autoupdate stream.
+//
+// Returns the last id, the data and the highest position of the messages. The
+// position is 0, if the messages do not have the field position.
+func parseMessageBus(reply any) (string, map[dskey.Key][]byte, int, error) {
 	data := make(map[dskey.Key][]byte)
+	var position int
 	databuilder := func(k, v []byte) {
+		if string(k) == positionField {
+			if p, err := strconv.Atoi(string(v)); err == nil && p > position {
+				position = p
+			}
+			return
+		}
+
 		key, err := dskey.FromString(string(k))
 		if err != nil {
 			// Ignore invalid keys
@@ -102,10 +115,10 @@ func parseMessageBus(reply any) (string, map[dskey.Key][]byte, error) {
 
 	lastID, err := onlyStream(reply, fieldChangedTopic, databuilder)
 	if err != nil {
-		return "", nil, fmt.Errorf("parsing autoupdate stream: %w", err)
+		return "", nil, 0, fmt.Errorf("parsing autoupdate stream: %w", err)
 	}
 
-	return lastID, data, nil
+	return lastID, data, position, nil
 }
 
 // logoutStream parses a redis logoutStream object to an list of sessionsIDs.
@@ -30,7 +30,7 @@ func TestStream(t *testing.T) {
 		t.Fatalf("Data is invalid json: %v", err)
 	}
 
-	id, got, err := parseMessageBus(data)
+	id, got, _, err := parseMessageBus(data)
 	if err != nil {
 		t.Errorf("Returned unexpected error %v", err)
 	}
@@ -49,6 +49,45 @@ func TestStream(t *testing.T) {
 	}
 }
 
+func TestStreamPosition(t *testing.T) {
+	var data any
+	err := json.Unmarshal([]byte(`
+	[
+		[
+			"ModifiedFields",
+			[
+				[
+					"12345-0",
+					["user/1/name", "Helga", "position", "5"]
+				],
+				[
+					"12346-0",
+					["position", "6", "user/1/name", "Hubert"]
+				]
+			]
+		]
+	]`), &data)
+	if err != nil {
+		t.Fatalf("Data is invalid json: %v", err)
+	}
+
+	_, got, position, err := parseMessageBus(data)
+	if err != nil {
+		t.Fatalf("Returned unexpected error %v", err)
+	}
+
+	if position != 6 {
+		t.Errorf("got position %d, expected 6", position)
+	}
+
+	expect := map[dskey.Key][]byte{
+		dskey.MustKey("user/1/name"): []byte("Hubert"),
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %v, expected %v", got, expect)
+	}
+}
+
 func TestStreamInvalidData(t *testing.T) {
 	td := []struct {
 		name string
@@ -78,7 +117,7 @@ func TestStreamInvalidData(t *testing.T) {
 				t.Fatalf("Data is invalid json: %v", err)
 			}
 
-			_, _, err = parseMessageBus(data)
+			_, _, _, err = parseMessageBus(data)
 			if err == nil {
 				t.Fatalf("Expected an error, got none")
 			}